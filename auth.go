@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+)
+
+// errUnauthorized is returned for requests without a valid token.
+var errUnauthorized = errors.New("unauthorized")
+
+// tokenSet holds the named shared secrets accepted by the server. Secrets are
+// stored as SHA-256 digests so every comparison covers the same length.
+type tokenSet struct {
+	names   []string
+	digests [][sha256.Size]byte
+}
+
+// newTokenSet builds a token set from a map of token name to secret.
+func newTokenSet(tokens map[string]string) (*tokenSet, error) {
+	names := make([]string, 0, len(tokens))
+	for name := range tokens {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	t := &tokenSet{}
+	for _, name := range names {
+		secret := tokens[name]
+		if secret == "" {
+			return nil, fmt.Errorf("token %q has an empty secret", name)
+		}
+		t.names = append(t.names, name)
+		t.digests = append(t.digests, sha256.Sum256([]byte(secret)))
+	}
+	return t, nil
+}
+
+// match returns the name of the token matching secret. Every configured
+// token is compared so the time taken does not reveal which one matched.
+func (t *tokenSet) match(secret string) (string, bool) {
+	digest := sha256.Sum256([]byte(secret))
+	matched := -1
+	for i := range t.digests {
+		if subtle.ConstantTimeCompare(digest[:], t.digests[i][:]) == 1 {
+			matched = i
+		}
+	}
+	if matched < 0 {
+		return "", false
+	}
+	return t.names[matched], true
+}
+
+// len returns the number of configured tokens.
+func (t *tokenSet) len() int {
+	if t == nil {
+		return 0
+	}
+	return len(t.names)
+}
+
+// changes compares t with next and returns the names of the tokens that
+// only next has, that only t has and whose secrets differ.
+func (t *tokenSet) changes(next *tokenSet) (added, removed, rotated []string) {
+	before, after := t.digestsByName(), next.digestsByName()
+	for name, digest := range after {
+		if old, ok := before[name]; !ok {
+			added = append(added, name)
+		} else if old != digest {
+			rotated = append(rotated, name)
+		}
+	}
+	for name := range before {
+		if _, ok := after[name]; !ok {
+			removed = append(removed, name)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	sort.Strings(rotated)
+	return added, removed, rotated
+}
+
+// digestsByName maps each token's name to the digest of its secret.
+func (t *tokenSet) digestsByName() map[string][sha256.Size]byte {
+	digests := make(map[string][sha256.Size]byte, t.len())
+	for i := 0; i < t.len(); i++ {
+		digests[t.names[i]] = t.digests[i]
+	}
+	return digests
+}
+
+// WithTokens requires every request to carry one of the secrets in tokens.
+// A nil or empty set leaves authentication disabled.
+func WithTokens(tokens *tokenSet) Option {
+	return func(s *Server) {
+		s.tokens = tokens
+	}
+}
+
+// authenticate checks token against the configured secrets and returns the
+// name of the matching token. It always succeeds when no tokens are set.
+func (s *Server) authenticate(token, remote string) (string, error) {
+	tokens := s.currentTokens()
+	if tokens.len() == 0 {
+		return "", nil
+	}
+
+	name, ok := tokens.match(token)
+	if !ok {
+		s.log.Warn("Unauthorized request", "remote_addr", remote)
+		return "", errUnauthorized
+	}
+	s.log.Debug("Authenticated request", "remote_addr", remote, "token_name", name)
+	return name, nil
+}
+
+// parseTokenSpec splits a "name:secret" token specification. A spec without
+// a name is named after its position.
+func parseTokenSpec(spec string, index int) (string, string) {
+	if name, secret, ok := strings.Cut(spec, ":"); ok && name != "" {
+		return name, secret
+	}
+	return fmt.Sprintf("token-%d", index+1), spec
+}
+
+// loadTokenFile reads token specifications from path, one per line. Blank
+// lines and lines starting with # are ignored.
+func loadTokenFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open token file: %w", err)
+	}
+	defer func() {
+		if err := f.Close(); err != nil {
+			slog.Warn("Error closing token file", "error", err)
+		}
+	}()
+
+	var specs []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		specs = append(specs, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read token file: %w", err)
+	}
+	return specs, nil
+}
+
+// buildTokenSet combines token specifications from flags, the environment
+// and a token file into a single set.
+func buildTokenSet(specs []string) (*tokenSet, error) {
+	tokens := make(map[string]string, len(specs))
+	for i, spec := range specs {
+		name, secret := parseTokenSpec(spec, i)
+		if _, dup := tokens[name]; dup {
+			return nil, fmt.Errorf("duplicate token name %q", name)
+		}
+		tokens[name] = secret
+	}
+	return newTokenSet(tokens)
+}
+
+// stringList is a flag.Value that collects every occurrence of a flag.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
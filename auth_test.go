@@ -0,0 +1,122 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTokenSetMatch(t *testing.T) {
+	tokens, err := buildTokenSet([]string{"vm1:secret-one", "vm2:secret-two", "bare-secret"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		secret string
+		name   string
+		ok     bool
+	}{
+		{"secret-one", "vm1", true},
+		{"secret-two", "vm2", true},
+		{"bare-secret", "token-3", true},
+		{"secret", "", false},
+		{"", "", false},
+	}
+
+	for _, tt := range tests {
+		name, ok := tokens.match(tt.secret)
+		if ok != tt.ok || name != tt.name {
+			t.Errorf("match(%q): expected (%q, %v), got (%q, %v)", tt.secret, tt.name, tt.ok, name, ok)
+		}
+	}
+}
+
+func TestBuildTokenSetErrors(t *testing.T) {
+	if _, err := buildTokenSet([]string{"vm1:a", "vm1:b"}); err == nil {
+		t.Error("expected error for duplicate token name")
+	}
+	if _, err := buildTokenSet([]string{"vm1:"}); err == nil {
+		t.Error("expected error for empty secret")
+	}
+}
+
+func TestLoadTokenFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens")
+	content := "# bridge tokens\nvm1:secret-one\n\n  vm2:secret-two  \n"
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("failed to write token file: %v", err)
+	}
+
+	specs, err := loadTokenFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Join(specs, ",") != "vm1:secret-one,vm2:secret-two" {
+		t.Errorf("unexpected specs: %v", specs)
+	}
+
+	if _, err := loadTokenFile(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("expected error for missing token file")
+	}
+}
+
+func TestTCPAuthentication(t *testing.T) {
+	tokens, err := buildTokenSet([]string{"vm1:secret-one"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	fake := &fakeNotifier{}
+	s := NewServer("localhost", 0, false, WithNotifier(fake), WithTokens(tokens))
+
+	if got := roundTrip(t, s, `{"title":"Test","message":"Hello"}`); got != "ERROR: Unauthorized" {
+		t.Errorf("expected unauthorized without token, got %q", got)
+	}
+	if got := roundTrip(t, s, `{"title":"Test","message":"Hello","token":"wrong"}`); got != "ERROR: Unauthorized" {
+		t.Errorf("expected unauthorized with wrong token, got %q", got)
+	}
+	if got := roundTrip(t, s, `{"title":"Test","message":"Hello","token":"secret-one"}`); got != "OK" {
+		t.Errorf("expected OK with valid token, got %q", got)
+	}
+	if got := len(fake.requests()); got != 1 {
+		t.Errorf("expected 1 delivered notification, got %d", got)
+	}
+}
+
+func TestHTTPAuthentication(t *testing.T) {
+	tokens, err := buildTokenSet([]string{"ci:secret-one"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	s := NewServer("localhost", 0, false, WithNotifier(&fakeNotifier{}), WithTokens(tokens))
+
+	tests := []struct {
+		name   string
+		body   string
+		header string
+		status int
+	}{
+		{"no token", `{"title":"T","message":"M"}`, "", http.StatusUnauthorized},
+		{"bearer header", `{"title":"T","message":"M"}`, "Bearer secret-one", http.StatusOK},
+		{"body token", `{"title":"T","message":"M","token":"secret-one"}`, "", http.StatusOK},
+		{"wrong bearer", `{"title":"T","message":"M"}`, "Bearer nope", http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/v1/notify", strings.NewReader(tt.body))
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			rec := httptest.NewRecorder()
+			s.httpHandler().ServeHTTP(rec, req)
+
+			if rec.Code != tt.status {
+				t.Errorf("expected status %d, got %d", tt.status, rec.Code)
+			}
+		})
+	}
+}
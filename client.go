@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// clientOptions describes how a subcommand reaches a running server.
+type clientOptions struct {
+	addr    string
+	socket  string
+	token   string
+	timeout time.Duration
+
+	tls     bool
+	tlsCA   string
+	tlsCert string
+	tlsKey  string
+}
+
+// addClientFlags registers the flags for connecting to a server on fs.
+func addClientFlags(fs *flag.FlagSet) *clientOptions {
+	c := &clientOptions{}
+	fs.StringVar(&c.addr, "addr", "localhost:9876", "Server address as host:port")
+	fs.StringVar(&c.socket, "socket", "", "Connect to the server's Unix domain socket instead of --addr")
+	fs.StringVar(&c.token, "token", "", "Auth token to send with each request")
+	fs.DurationVar(&c.timeout, "timeout", time.Minute, "How long to wait for each response")
+	fs.BoolVar(&c.tls, "tls", false, "Connect to --addr over TLS")
+	fs.StringVar(&c.tlsCA, "tls-ca", "", "CA bundle to verify the server certificate with instead of the system roots (implies --tls)")
+	fs.StringVar(&c.tlsCert, "tls-cert", "", "Client certificate for servers that require one (implies --tls)")
+	fs.StringVar(&c.tlsKey, "tls-key", "", "Private key for --tls-cert")
+	return c
+}
+
+func (c *clientOptions) dial() (net.Conn, error) {
+	if c.socket != "" {
+		return net.DialTimeout("unix", c.socket, c.timeout)
+	}
+	if !c.tls && c.tlsCA == "" && c.tlsCert == "" && c.tlsKey == "" {
+		return net.DialTimeout("tcp", c.addr, c.timeout)
+	}
+	cfg, err := c.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	return tls.DialWithDialer(&net.Dialer{Timeout: c.timeout}, "tcp", c.addr, cfg)
+}
+
+// tlsConfig returns the configuration for connecting to the server over
+// TLS, verifying its certificate against the CA bundle if one is given.
+func (c *clientOptions) tlsConfig() (*tls.Config, error) {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if c.tlsCA != "" {
+		pem, err := os.ReadFile(c.tlsCA)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read TLS CA file: %w", err)
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in TLS CA file %s", c.tlsCA)
+		}
+	}
+	if c.tlsCert != "" || c.tlsKey != "" {
+		if c.tlsCert == "" || c.tlsKey == "" {
+			return nil, errors.New("both a TLS client certificate and key are required")
+		}
+		cert, err := tls.LoadX509KeyPair(c.tlsCert, c.tlsKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load TLS client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+// send writes req to the server on a new connection and returns its
+// response line in the legacy text format, whatever protocol version req
+// was first sent with.
+func (c *clientOptions) send(req NotificationRequest) (string, error) {
+	if c.token != "" {
+		req.Token = c.token
+	}
+	req.Version = 0
+	data, err := json.Marshal(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode request: %w", err)
+	}
+
+	conn, err := c.dial()
+	if err != nil {
+		return "", fmt.Errorf("failed to connect: %w", err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	if err := conn.SetDeadline(time.Now().Add(c.timeout)); err != nil {
+		return "", err
+	}
+	if _, err := conn.Write(append(data, '\n')); err != nil {
+		return "", fmt.Errorf("failed to send request: %w", err)
+	}
+	resp, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return "", fmt.Errorf("failed to read response: %w", err)
+	}
+	return strings.TrimSpace(resp), nil
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+const (
+	// defaultCoalesceMax is how many notifications a digest may combine
+	// before it is sent early.
+	defaultCoalesceMax = 50
+	// defaultCoalesceTemplate renders the message of a digest notification.
+	defaultCoalesceTemplate = "{{.Count}} notifications from {{.Group}}: {{.Summary}}"
+)
+
+// pendingNotification is a notification held back while its group's batch
+// is open. If done is non-nil the delivery result is sent on it.
+type pendingNotification struct {
+	id   string
+	req  NotificationRequest
+	done chan error
+}
+
+// batch collects the notifications for one group until it is flushed.
+type batch struct {
+	members []pendingNotification
+	timer   *time.Timer
+}
+
+// digestData is available to the digest template.
+type digestData struct {
+	Group    string
+	Count    int
+	Summary  string
+	Titles   []string
+	Messages []string
+}
+
+// coalescer merges notifications for the same group that arrive within a
+// window into a single digest notification.
+type coalescer struct {
+	window   time.Duration
+	maxBatch int
+	tmpl     *template.Template
+	flush    func(group string, members []pendingNotification)
+
+	mu      sync.Mutex
+	batches map[string]*batch
+	closed  bool
+}
+
+// newCoalescer returns a coalescer that holds notifications for window,
+// combining up to maxBatch of them into a digest whose message is rendered
+// from tmpl.
+func newCoalescer(window time.Duration, maxBatch int, tmpl string) (*coalescer, error) {
+	t, err := template.New("digest").Parse(tmpl)
+	if err != nil {
+		return nil, fmt.Errorf("invalid coalesce template: %w", err)
+	}
+	if maxBatch < 1 {
+		maxBatch = 1
+	}
+	return &coalescer{
+		window:   window,
+		maxBatch: maxBatch,
+		tmpl:     t,
+		batches:  make(map[string]*batch),
+	}, nil
+}
+
+// WithCoalescer merges bursts of notifications for the same group into
+// digests. Notifications without a group are delivered individually.
+func WithCoalescer(c *coalescer) Option {
+	return func(s *Server) {
+		s.coalescer = c
+	}
+}
+
+// add holds n until its group's batch is flushed, opening a batch if
+// needed. A full batch is flushed straight away.
+func (c *coalescer) add(n pendingNotification) error {
+	group := n.req.Group
+
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return errQueueClosed
+	}
+	b, ok := c.batches[group]
+	if !ok {
+		b = &batch{}
+		b.timer = time.AfterFunc(c.window, func() { c.flushBatch(group, b) })
+		c.batches[group] = b
+	}
+	b.members = append(b.members, n)
+	full := len(b.members) >= c.maxBatch
+	c.mu.Unlock()
+
+	if full {
+		c.flushBatch(group, b)
+	}
+	return nil
+}
+
+// flushBatch sends b if it is still the open batch for group.
+func (c *coalescer) flushBatch(group string, b *batch) {
+	c.mu.Lock()
+	if c.batches[group] != b {
+		c.mu.Unlock()
+		return
+	}
+	delete(c.batches, group)
+	b.timer.Stop()
+	c.mu.Unlock()
+
+	c.flush(group, b.members)
+}
+
+// close stops accepting notifications and flushes every open batch.
+func (c *coalescer) close() {
+	c.mu.Lock()
+	c.closed = true
+	batches := c.batches
+	c.batches = make(map[string]*batch)
+	c.mu.Unlock()
+
+	for group, b := range batches {
+		b.timer.Stop()
+		c.flush(group, b.members)
+	}
+}
+
+// digest builds the notification that stands in for members.
+func (c *coalescer) digest(group string, members []pendingNotification) (NotificationRequest, error) {
+	return buildDigest(c.tmpl, group, members)
+}
+
+// buildDigest renders a single notification summarizing members, with its
+// message from tmpl. The digest keeps the members' title, group and click
+// target when they all agree; otherwise it is titled fallbackTitle.
+func buildDigest(tmpl *template.Template, fallbackTitle string, members []pendingNotification) (NotificationRequest, error) {
+	first := members[0].req
+	group := ""
+	if sameField(members, func(r NotificationRequest) string { return r.Group }) {
+		group = first.Group
+	}
+
+	data := digestData{Group: group, Count: len(members)}
+	if data.Group == "" {
+		data.Group = fallbackTitle
+	}
+	counts := make(map[string]int)
+	var order []string
+	for _, m := range members {
+		data.Titles = append(data.Titles, m.req.Title)
+		data.Messages = append(data.Messages, m.req.Message)
+		if counts[m.req.Message] == 0 {
+			order = append(order, m.req.Message)
+		}
+		counts[m.req.Message]++
+	}
+	sort.SliceStable(order, func(i, j int) bool { return counts[order[i]] > counts[order[j]] })
+	parts := make([]string, len(order))
+	for i, msg := range order {
+		parts[i] = fmt.Sprintf("%d %s", counts[msg], msg)
+	}
+	data.Summary = strings.Join(parts, ", ")
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return NotificationRequest{}, fmt.Errorf("failed to render digest: %w", err)
+	}
+
+	req := NotificationRequest{
+		Title:     fallbackTitle,
+		Message:   buf.String(),
+		Sound:     first.Sound,
+		Group:     group,
+		IgnoreDnD: first.IgnoreDnD,
+	}
+	if sameField(members, func(r NotificationRequest) string { return r.Title }) {
+		req.Title = first.Title
+	}
+	if sameField(members, func(r NotificationRequest) string { return r.Open }) {
+		req.Open = first.Open
+	}
+	return req, nil
+}
+
+// sameField reports whether field has the same value in every member.
+func sameField(members []pendingNotification, field func(NotificationRequest) string) bool {
+	for _, m := range members[1:] {
+		if field(m.req) != field(members[0].req) {
+			return false
+		}
+	}
+	return true
+}
+
+// unexpired returns the members that have not expired, dropping the rest
+// and telling any waiting clients.
+func (s *Server) unexpired(members []pendingNotification) []pendingNotification {
+	now := time.Now()
+	var live []pendingNotification
+	for _, m := range members {
+		if !m.req.expired(now) {
+			live = append(live, m)
+			continue
+		}
+		s.dropExpired(m.id, m.req)
+		if m.done != nil {
+			m.done <- errExpired
+		}
+	}
+	return live
+}
+
+// coalesce holds req for its group's digest. If wait is set it waits for
+// the digest to be delivered. If acked is set the client was already told
+// about req, so its spool entry is kept even if it cannot be held.
+func (s *Server) coalesce(id string, req NotificationRequest, wait, acked bool) (result, error) {
+	n := pendingNotification{id: id, req: req}
+	if wait {
+		n.done = make(chan error, 1)
+	}
+	// Persist before acknowledging so the notification survives a restart
+	if s.spool != nil {
+		if err := s.spool.add(id, req); err != nil {
+			return result{}, err
+		}
+	}
+	if err := s.coalescer.add(n); err != nil {
+		if s.spool != nil && !acked {
+			s.spool.remove(id)
+		}
+		return result{}, err
+	}
+	if !wait {
+		return result{status: statusQueued, id: id}, nil
+	}
+	if err := <-n.done; errors.Is(err, errExpired) {
+		return result{status: statusExpired, id: id}, nil
+	} else if err != nil {
+		return result{}, err
+	}
+	return result{status: statusOK, id: id}, nil
+}
+
+// flushDigest delivers the notifications collected for group: as they are
+// if there is only one, otherwise as a single digest.
+func (s *Server) flushDigest(group string, members []pendingNotification) {
+	if members = s.unexpired(members); len(members) == 0 {
+		return
+	}
+	id, req := members[0].id, members[0].req
+	var err error
+	if len(members) > 1 {
+		id = newID()
+		req, err = s.coalescer.digest(group, members)
+		if err == nil {
+			s.recordDigest(id, req, members)
+			s.log.Debug("Coalesced notifications", "group", group, "count", len(members), "request_id", id)
+		}
+	}
+	job := deliveryJob{id: id, req: req}
+	for _, m := range members {
+		if m.done != nil {
+			job.done = make(chan error, 1)
+			break
+		}
+	}
+	// Asynchronous clients were told QUEUED, so the batch waits for room in
+	// the queue
+	if err == nil {
+		err = s.handOff(job, members)
+	}
+	if err == nil && job.done != nil {
+		if err = <-job.done; errors.Is(err, errExpired) {
+			err = nil
+		}
+	}
+
+	if err != nil {
+		s.updateHistory(id, historyFailed, err)
+	}
+	for _, m := range members {
+		if err != nil {
+			s.forgetDuplicate(m.req, m.id)
+			s.updateHistory(m.id, historyFailed, err)
+		}
+		if m.done != nil {
+			m.done <- err
+		}
+	}
+}
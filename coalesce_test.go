@@ -0,0 +1,200 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCoalescerDigest(t *testing.T) {
+	c, err := newCoalescer(time.Second, 50, defaultCoalesceTemplate)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var members []pendingNotification
+	for i := 0; i < 12; i++ {
+		msg := "passed"
+		if i%6 == 5 {
+			msg = "failed"
+		}
+		members = append(members, pendingNotification{req: NotificationRequest{Title: "Shard", Message: msg, Group: "ci", Sound: "Hero"}})
+	}
+
+	got, err := c.digest("ci", members)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := NotificationRequest{Title: "Shard", Message: "12 notifications from ci: 10 passed, 2 failed", Group: "ci", Sound: "Hero"}
+	if got != want {
+		t.Errorf("expected %+v, got %+v", want, got)
+	}
+
+	members[0].req.Title = "Other"
+	if got, _ := c.digest("ci", members); got.Title != "ci" {
+		t.Errorf("expected mixed titles to fall back to the group, got %q", got.Title)
+	}
+
+	if _, err := newCoalescer(time.Second, 50, "{{.Count"); err == nil {
+		t.Error("expected invalid template to be rejected")
+	}
+}
+
+func TestCoalescingMergesBurst(t *testing.T) {
+	c, err := newCoalescer(50*time.Millisecond, 50, "{{.Count}} from {{.Group}}: {{.Summary}}")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	fake := &fakeNotifier{}
+	s := NewServer("localhost", 0, false, WithNotifier(fake), WithCoalescer(c))
+	t.Cleanup(s.Stop)
+
+	// Synchronous clients all wait for the digest
+	lines := []string{
+		`{"title":"Tests","message":"passed","group":"ci"}`,
+		`{"title":"Tests","message":"failed","group":"ci"}`,
+		`{"title":"Tests","message":"passed","group":"ci"}`,
+		`{"title":"Mail","message":"New message"}`,
+	}
+	var wg sync.WaitGroup
+	for _, line := range lines {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if got := roundTrip(t, s, line); got != "OK" {
+				t.Errorf("expected OK, got %q", got)
+			}
+		}()
+	}
+	wg.Wait()
+
+	reqs := fake.requests()
+	if len(reqs) != 2 {
+		t.Fatalf("expected the ungrouped notification and one digest, got %+v", reqs)
+	}
+	var digest NotificationRequest
+	for _, r := range reqs {
+		if r.Group == "ci" {
+			digest = r
+		}
+	}
+	if digest.Message != "3 from ci: 2 passed, 1 failed" || digest.Title != "Tests" {
+		t.Errorf("unexpected digest %+v", digest)
+	}
+}
+
+func TestCoalescingSingleAndFullBatches(t *testing.T) {
+	c, err := newCoalescer(time.Hour, 2, defaultCoalesceTemplate)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	fake := &fakeNotifier{}
+	s := NewServer("localhost", 0, false, WithNotifier(fake), WithCoalescer(c), WithAsync())
+
+	// A full batch is sent without waiting for the window
+	for i := 0; i < 2; i++ {
+		if got := roundTrip(t, s, `{"title":"Build","message":"Done","group":"ci"}`); !strings.HasPrefix(got, "QUEUED ") {
+			t.Fatalf("expected QUEUED, got %q", got)
+		}
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for len(fake.requests()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if reqs := fake.requests(); len(reqs) != 1 || !strings.HasPrefix(reqs[0].Message, "2 notifications from ci") {
+		t.Fatalf("expected a digest of the full batch, got %+v", reqs)
+	}
+
+	// A lone notification is sent as it is when the server stops
+	if got := roundTrip(t, s, `{"title":"Deploy","message":"Started","group":"cd"}`); !strings.HasPrefix(got, "QUEUED ") {
+		t.Fatalf("expected QUEUED, got %q", got)
+	}
+	s.Stop()
+	reqs := fake.requests()
+	if len(reqs) != 2 || reqs[1].Title != "Deploy" || reqs[1].Message != "Started" {
+		t.Errorf("expected the held notification to be delivered unchanged, got %+v", reqs)
+	}
+}
+
+func TestCoalescingSpoolsHeldNotifications(t *testing.T) {
+	dir := t.TempDir()
+	sp, err := openSpool(dir, 0, 0)
+	if err != nil {
+		t.Fatalf("failed to open spool: %v", err)
+	}
+	c, err := newCoalescer(time.Hour, 50, defaultCoalesceTemplate)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	fake := &fakeNotifier{}
+	s := NewServer("localhost", 0, false, WithNotifier(fake), WithCoalescer(c), WithSpool(sp), WithAsync())
+
+	// Held notifications are spooled before they are acknowledged
+	for _, msg := range []string{"passed", "failed"} {
+		if got := roundTrip(t, s, `{"title":"Tests","message":"`+msg+`","group":"ci"}`); !strings.HasPrefix(got, "QUEUED ") {
+			t.Fatalf("expected QUEUED, got %q", got)
+		}
+	}
+	if names, _ := os.ReadDir(dir); len(names) != 2 {
+		t.Fatalf("expected both held notifications in the spool, got %d files", len(names))
+	}
+
+	// Once the digest is delivered nothing is left to replay
+	s.Stop()
+	if reqs := fake.requests(); len(reqs) != 1 || !strings.HasPrefix(reqs[0].Message, "2 notifications from ci") {
+		t.Fatalf("expected a digest, got %+v", reqs)
+	}
+	if names, _ := os.ReadDir(dir); len(names) != 0 {
+		t.Errorf("expected empty spool after delivery, got %d files", len(names))
+	}
+}
+
+func TestCoalescingWaitsForFullQueue(t *testing.T) {
+	sp := testSpool(t)
+	c, err := newCoalescer(50*time.Millisecond, 50, defaultCoalesceTemplate)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	backend := newBlockingNotifier()
+	s := NewServer("localhost", 0, false, WithNotifier(backend), WithCoalescer(c), WithSpool(sp), WithQueue(1, 1), WithAsync())
+	released := false
+	t.Cleanup(func() {
+		if !released {
+			close(backend.release)
+		}
+		s.Stop()
+	})
+
+	got := roundTrip(t, s, `{"title":"Tests","message":"passed","group":"ci"}`)
+	id, ok := strings.CutPrefix(got, "QUEUED ")
+	if !ok {
+		t.Fatalf("expected QUEUED, got %q", got)
+	}
+
+	// Ungrouped notifications occupy the only worker and the only queue slot
+	if got := roundTrip(t, s, `{"title":"Mail","message":"1"}`); !strings.HasPrefix(got, "QUEUED ") {
+		t.Fatalf("expected QUEUED, got %q", got)
+	}
+	<-backend.started
+	if got := roundTrip(t, s, `{"title":"Mail","message":"2"}`); !strings.HasPrefix(got, "QUEUED ") {
+		t.Fatalf("expected QUEUED, got %q", got)
+	}
+
+	// Flushed into a full queue, the lone member waits in the spool
+	time.Sleep(200 * time.Millisecond)
+	if _, err := os.Stat(sp.path(id)); err != nil {
+		t.Fatalf("expected the held notification to stay spooled, got %v", err)
+	}
+
+	close(backend.release)
+	released = true
+	deadline := time.Now().Add(2 * time.Second)
+	for len(backend.requests()) < 3 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if reqs := backend.requests(); len(reqs) != 3 || reqs[2].Title != "Tests" {
+		t.Errorf("expected the held notification to be delivered after the queue drained, got %+v", reqs)
+	}
+}
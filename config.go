@@ -0,0 +1,815 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// configFileName is the name of the config file looked for in the config
+// directory when --config is not given.
+const configFileName = "config.toml"
+
+// Sources of a setting, as shown by config print-effective.
+const (
+	sourceDefault = "default"
+	sourceFlag    = "flag"
+	sourceEnv     = "env"
+	sourceFile    = "file"
+)
+
+// shortFlags maps each short flag to the long flag it is an alias for.
+var shortFlags = map[string]string{
+	"p": "port",
+	"h": "host",
+	"v": "verbose",
+}
+
+// envAliases are environment variables accepted in addition to the MNB_*
+// name of a setting, for compatibility with earlier versions.
+var envAliases = map[string]string{
+	"port":  "PORT",
+	"token": "MNB_TOKENS",
+}
+
+// envListSeparators overrides the comma separating the values of a
+// repeatable setting given in the environment, for settings whose values
+// may themselves contain commas.
+var envListSeparators = map[string]string{
+	"quiet-hours": ";",
+}
+
+// settings holds the server's configuration, gathered from flags, MNB_*
+// environment variables, the config file and defaults, in that order of
+// precedence.
+type settings struct {
+	port         int
+	httpPort     int
+	host         string
+	idleTimeout  time.Duration
+	verbose      bool
+	logLevel     string
+	logFormat    string
+	workers      int
+	queueDepth   int
+	async        bool
+	spoolDir     string
+	spoolBytes   int64
+	spoolAge     time.Duration
+	retries      int
+	retryDelay   time.Duration
+	retryJitter  float64
+	deadLetters  string
+	rateLimit    string
+	clientLimits stringList
+	dedupWindow  time.Duration
+	coalesceWin  time.Duration
+	coalesceMax  int
+	coalesceTpl  string
+	metricsAddr  string
+	historySize  int
+	historyAge   time.Duration
+	quietSpecs   stringList
+	quietMode    string
+	tokenSpecs   stringList
+	tokenFile    string
+	socketPath   string
+	socketMode   string
+	socketOwner  string
+	noTCP        bool
+	tlsCert      string
+	tlsKey       string
+	tlsClientCA  string
+	backend      string
+	configPath   string
+	showVersion  bool
+
+	// flags is the flag set the settings were loaded with
+	flags *flag.FlagSet
+	// configFile is the config file that was read, if any
+	configFile string
+	// sources records where each setting was taken from, by flag name
+	sources map[string]string
+}
+
+// flagSet returns a flag set that parses the server's flags into st.
+func (st *settings) flagSet(name string, errorHandling flag.ErrorHandling) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, errorHandling)
+	fs.IntVar(&st.port, "port", 9876, "Port to listen on")
+	fs.IntVar(&st.port, "p", 9876, "Port to listen on (short)")
+	fs.IntVar(&st.httpPort, "http-port", 0, "Port for the HTTP API (0 disables it)")
+	fs.StringVar(&st.host, "host", "0.0.0.0", "Host to bind to")
+	fs.StringVar(&st.host, "h", "0.0.0.0", "Host to bind to (short)")
+	fs.DurationVar(&st.idleTimeout, "idle-timeout", defaultIdleTimeout, "Close TCP connections idle for this long")
+	fs.BoolVar(&st.verbose, "verbose", false, "Log at debug level (same as --log-level debug)")
+	fs.BoolVar(&st.verbose, "v", false, "Log at debug level (short)")
+	fs.StringVar(&st.logLevel, "log-level", "info", "Minimum level to log: debug, info, warn or error")
+	fs.StringVar(&st.logFormat, "log-format", logFormatText, "Log format: text or json")
+	fs.IntVar(&st.workers, "workers", defaultWorkers, "Number of notifications delivered concurrently")
+	fs.IntVar(&st.queueDepth, "queue-depth", defaultQueueDepth, "Notifications that may wait for a worker before requests are rejected")
+	fs.BoolVar(&st.async, "async", false, "Acknowledge notifications with QUEUED <id> instead of waiting for delivery")
+	fs.StringVar(&st.spoolDir, "spool-dir", "", "Persist accepted notifications in this directory until delivered")
+	fs.Int64Var(&st.spoolBytes, "spool-max-bytes", defaultSpoolMaxBytes, "Maximum total size of the spool in bytes")
+	fs.DurationVar(&st.spoolAge, "spool-max-age", defaultSpoolMaxAge, "Discard spooled notifications older than this")
+	fs.IntVar(&st.retries, "retry-attempts", defaultRetryAttempts, "Delivery attempts before a notification is given up on")
+	fs.DurationVar(&st.retryDelay, "retry-delay", defaultRetryDelay, "Wait before the first retry, doubled for each further attempt")
+	fs.Float64Var(&st.retryJitter, "retry-jitter", defaultRetryJitter, "Randomize retry delays by up to this fraction")
+	fs.StringVar(&st.deadLetters, "dead-letter", "", "Append notifications that fail every attempt to this file")
+	fs.StringVar(&st.rateLimit, "rate-limit", "0", "Requests allowed per client, as N, N/s, N/m or N/h, optionally followed by :burst (0 disables)")
+	fs.Var(&st.clientLimits, "rate-limit-client", "Rate limit for one client as token-name-or-IP=rate[:burst] (repeatable)")
+	fs.DurationVar(&st.dedupWindow, "dedup-window", 0, "Answer repeats of a notification within this window with OK duplicate (0 disables)")
+	fs.DurationVar(&st.coalesceWin, "coalesce-window", 0, "Merge notifications for the same group within this window into a digest (0 disables)")
+	fs.IntVar(&st.coalesceMax, "coalesce-max", defaultCoalesceMax, "Maximum notifications merged into one digest")
+	fs.StringVar(&st.coalesceTpl, "coalesce-template", defaultCoalesceTemplate, "Go template for the digest message")
+	fs.StringVar(&st.metricsAddr, "metrics-addr", "", "Serve Prometheus metrics at /metrics on this host:port (see the list below)")
+	fs.IntVar(&st.historySize, "history-size", defaultHistorySize, "Notifications kept for history queries (0 disables history)")
+	fs.DurationVar(&st.historyAge, "history-max-age", defaultHistoryMaxAge, "Drop notifications older than this from the history (0 keeps them until evicted by --history-size)")
+	fs.Var(&st.quietSpecs, "quiet-hours", "Quiet hours as [days] HH:MM-HH:MM in local time, e.g. \"Mon-Fri 22:00-07:00\" (repeatable)")
+	fs.StringVar(&st.quietMode, "quiet-mode", quietHold, "What to do with notifications during quiet hours: hold, drop or silent")
+	fs.Var(&st.tokenSpecs, "token", "Auth token as name:secret (repeatable)")
+	fs.StringVar(&st.tokenFile, "token-file", "", "File of name:secret auth tokens, one per line")
+	fs.StringVar(&st.socketPath, "socket", "", "Also listen on this Unix domain socket path")
+	fs.StringVar(&st.socketMode, "socket-mode", "0600", "File mode for the Unix socket (octal)")
+	fs.StringVar(&st.socketOwner, "socket-owner", "", "Owner of the Unix socket as user[:group]")
+	fs.BoolVar(&st.noTCP, "no-tcp", false, "Disable the TCP listener (requires --socket)")
+	fs.StringVar(&st.tlsCert, "tls-cert", "", "TLS certificate file (enables TLS)")
+	fs.StringVar(&st.tlsKey, "tls-key", "", "TLS private key file")
+	fs.StringVar(&st.tlsClientCA, "tls-client-ca", "", "CA bundle for verifying client certificates (enables mutual TLS)")
+	fs.StringVar(&st.backend, "backend", defaultBackend, fmt.Sprintf("Notification backend %v", NotifierNames()))
+	fs.StringVar(&st.configPath, "config", "", "Config file (default $XDG_CONFIG_HOME/macos-notify-bridge/"+configFileName+" if it exists)")
+	fs.BoolVar(&st.showVersion, "version", false, "Show version")
+	return fs
+}
+
+// configurable reports whether the flag may also be set from the
+// environment or the config file.
+func configurable(name string) bool {
+	_, short := shortFlags[name]
+	return !short && name != "config" && name != "version"
+}
+
+// load parses args with fs, which must come from st.flagSet, and fills in
+// every setting not given as a flag from the environment, read with getenv,
+// and then the config file.
+func (st *settings) load(fs *flag.FlagSet, args []string, getenv func(string) string) error {
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	st.flags = fs
+
+	st.sources = make(map[string]string)
+	fs.Visit(func(f *flag.Flag) {
+		name := f.Name
+		if long, ok := shortFlags[name]; ok {
+			name = long
+		}
+		st.sources[name] = sourceFlag
+	})
+
+	path, err := configPath(st.configPath, getenv)
+	if err != nil {
+		return err
+	}
+	var entries map[string]configEntry
+	if path != "" {
+		if entries, err = readConfigFile(path); err != nil {
+			return err
+		}
+		for key, e := range entries {
+			if fs.Lookup(key) == nil || !configurable(key) {
+				return fmt.Errorf("%s:%d: unknown setting %q", path, e.line, key)
+			}
+		}
+		st.configFile = path
+	}
+
+	var errs []error
+	fs.VisitAll(func(f *flag.Flag) {
+		if !configurable(f.Name) || st.sources[f.Name] != "" {
+			return
+		}
+		if env, value := lookupEnv(f.Name, getenv); value != "" {
+			values := []string{value}
+			if _, ok := f.Value.(*stringList); ok {
+				values = splitEnvList(f.Name, value)
+			}
+			for _, v := range values {
+				if err := fs.Set(f.Name, v); err != nil {
+					errs = append(errs, fmt.Errorf("invalid %s %q: %w", env, v, err))
+					return
+				}
+			}
+			st.sources[f.Name] = sourceEnv + " " + env
+			return
+		}
+		if e, ok := entries[f.Name]; ok {
+			if _, list := f.Value.(*stringList); !list && len(e.values) != 1 {
+				errs = append(errs, fmt.Errorf("%s:%d: %s takes a single value", path, e.line, f.Name))
+				return
+			}
+			for _, v := range e.values {
+				if err := fs.Set(f.Name, v); err != nil {
+					errs = append(errs, fmt.Errorf("%s:%d: invalid %s %q: %w", path, e.line, f.Name, v, err))
+					return
+				}
+			}
+			st.sources[f.Name] = sourceFile
+		}
+	})
+	return errors.Join(errs...)
+}
+
+// lookupEnv returns the environment variable setting the named flag and
+// its value, which is empty if none is set.
+func lookupEnv(name string, getenv func(string) string) (string, string) {
+	env := "MNB_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+	if value := getenv(env); value != "" {
+		return env, value
+	}
+	if alias, ok := envAliases[name]; ok {
+		if value := getenv(alias); value != "" {
+			return alias, value
+		}
+	}
+	return env, ""
+}
+
+// splitEnvList splits the value of a repeatable setting given in the
+// environment into its values.
+func splitEnvList(name, value string) []string {
+	sep := ","
+	if s, ok := envListSeparators[name]; ok {
+		sep = s
+	}
+	var values []string
+	for _, v := range strings.Split(value, sep) {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// configPath returns the config file to read: the one given with --config
+// or MNB_CONFIG, or else the default one if it exists. It returns an empty
+// path if there is none.
+func configPath(path string, getenv func(string) string) (string, error) {
+	if path == "" {
+		path = getenv("MNB_CONFIG")
+	}
+	if path != "" {
+		return path, nil
+	}
+
+	dir := getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", nil
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	path = filepath.Join(dir, "macos-notify-bridge", configFileName)
+	if _, err := os.Stat(path); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return "", nil
+		}
+		return "", fmt.Errorf("failed to read config file: %w", err)
+	}
+	return path, nil
+}
+
+// configEntry is a setting read from the config file.
+type configEntry struct {
+	values []string
+	line   int
+}
+
+func readConfigFile(path string) (map[string]configEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	entries, err := parseConfig(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s:%w", path, err)
+	}
+	return entries, nil
+}
+
+// parseConfig parses a config file written in a subset of TOML: key = value
+// pairs whose values are strings, numbers, booleans or arrays of these, and
+// [section] headers that prefix the keys below them, so that key under
+// [section] is the setting section-key. Keys are the names of the long
+// flags; underscores may be used in place of hyphens.
+func parseConfig(r io.Reader) (map[string]configEntry, error) {
+	entries := make(map[string]configEntry)
+	scanner := bufio.NewScanner(r)
+	section := ""
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(stripComment(scanner.Text()))
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") {
+			name, ok := strings.CutSuffix(line[1:], "]")
+			if !ok || strings.TrimSpace(name) == "" {
+				return nil, fmt.Errorf("%d: invalid section header %q", lineNo, line)
+			}
+			section = strings.TrimSpace(name)
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("%d: expected key = value", lineNo)
+		}
+		key = strings.TrimSpace(key)
+		if key == "" {
+			return nil, fmt.Errorf("%d: missing key", lineNo)
+		}
+		if section != "" {
+			key = section + "-" + key
+		}
+		key = strings.ReplaceAll(key, "_", "-")
+
+		// Arrays may continue over several lines
+		start := lineNo
+		value = strings.TrimSpace(value)
+		for bracketDepth(value) > 0 {
+			if !scanner.Scan() {
+				return nil, fmt.Errorf("%d: unterminated array", start)
+			}
+			lineNo++
+			value += " " + strings.TrimSpace(stripComment(scanner.Text()))
+		}
+
+		values, err := parseConfigValue(value)
+		if err != nil {
+			return nil, fmt.Errorf("%d: %s: %w", start, key, err)
+		}
+		if _, dup := entries[key]; dup {
+			return nil, fmt.Errorf("%d: %s set more than once", start, key)
+		}
+		entries[key] = configEntry{values: values, line: start}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%d: %w", lineNo, err)
+	}
+	return entries, nil
+}
+
+// parseConfigValue parses a value, returning each element of an array.
+func parseConfigValue(s string) ([]string, error) {
+	if s == "" {
+		return nil, errors.New("missing value")
+	}
+	if !strings.HasPrefix(s, "[") {
+		value, rest, err := scanConfigValue(s)
+		if err != nil {
+			return nil, err
+		}
+		if rest = strings.TrimSpace(rest); rest != "" {
+			return nil, fmt.Errorf("unexpected %q after value", rest)
+		}
+		return []string{value}, nil
+	}
+
+	inner, ok := strings.CutSuffix(s[1:], "]")
+	if !ok {
+		return nil, errors.New("unterminated array")
+	}
+	values := []string{}
+	for rest := strings.TrimSpace(inner); rest != ""; {
+		value, after, err := scanConfigValue(rest)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+		after = strings.TrimSpace(after)
+		if after == "" {
+			break
+		}
+		if after[0] != ',' {
+			return nil, fmt.Errorf("expected , between array elements, got %q", after)
+		}
+		rest = strings.TrimSpace(after[1:])
+	}
+	return values, nil
+}
+
+// scanConfigValue scans a string, number or boolean from the start of s and
+// returns it along with the rest of s.
+func scanConfigValue(s string) (string, string, error) {
+	switch s[0] {
+	case '"':
+		for i := 1; i < len(s); i++ {
+			switch s[i] {
+			case '\\':
+				i++
+			case '"':
+				value, err := strconv.Unquote(s[:i+1])
+				if err != nil {
+					return "", "", fmt.Errorf("invalid string %s", s[:i+1])
+				}
+				return value, s[i+1:], nil
+			}
+		}
+		return "", "", errors.New("unterminated string")
+	case '\'':
+		value, rest, ok := strings.Cut(s[1:], "'")
+		if !ok {
+			return "", "", errors.New("unterminated string")
+		}
+		return value, rest, nil
+	case '[':
+		return "", "", errors.New("nested arrays are not supported")
+	}
+
+	end := strings.IndexAny(s, ",]")
+	if end < 0 {
+		end = len(s)
+	}
+	value := strings.TrimSpace(s[:end])
+	if value == "" {
+		return "", "", errors.New("missing value")
+	}
+	return value, s[end:], nil
+}
+
+// stripComment removes a # comment from a line, leaving any # inside a
+// string alone.
+func stripComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote == '"' && c == '\\':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// bracketDepth returns how many array brackets in s are left open.
+func bracketDepth(s string) int {
+	depth := 0
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote == '"' && c == '\\':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '[':
+			depth++
+		case c == ']':
+			depth--
+		}
+	}
+	return depth
+}
+
+// logger returns the logger configured by the settings, writing to w, and
+// the level it logs at. --verbose means debug unless a level is set.
+func (st *settings) logger(w io.Writer) (*slog.Logger, slog.Level, error) {
+	level, err := parseLogLevel(st.logLevel)
+	if err != nil {
+		return nil, 0, err
+	}
+	if st.verbose && st.sources["log-level"] == "" {
+		level = slog.LevelDebug
+	}
+	logger, err := newLogger(w, st.logFormat, level)
+	if err != nil {
+		return nil, 0, err
+	}
+	return logger, level, nil
+}
+
+// options returns the server options configured by the settings, other
+// than the notifier, the logger and those opening the spool and the
+// dead-letter file, which openStores returns.
+func (st *settings) options() ([]Option, error) {
+	tokens, err := st.tokens()
+	if err != nil {
+		return nil, err
+	}
+
+	opts := []Option{
+		WithHTTPPort(st.httpPort),
+		WithIdleTimeout(st.idleTimeout),
+		WithTokens(tokens),
+		WithQueue(st.workers, st.queueDepth),
+		WithRetry(st.retries, st.retryDelay, st.retryJitter),
+		WithDedupWindow(st.dedupWindow),
+		WithHistory(st.historySize, st.historyAge),
+		WithMetricsAddr(st.metricsAddr),
+	}
+	if st.async {
+		opts = append(opts, WithAsync())
+	}
+	if limiter, err := buildRateLimiter(st.rateLimit, st.clientLimits); err != nil {
+		return nil, err
+	} else if limiter != nil {
+		opts = append(opts, WithRateLimit(limiter))
+	}
+	if st.coalesceWin > 0 {
+		c, err := newCoalescer(st.coalesceWin, st.coalesceMax, st.coalesceTpl)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, WithCoalescer(c))
+	}
+	if len(st.quietSpecs) > 0 {
+		q, err := newQuietHours(st.quietSpecs, st.quietMode)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, WithQuietHours(q))
+	}
+	if st.socketPath != "" {
+		mode, err := strconv.ParseUint(st.socketMode, 8, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --socket-mode %q: %w", st.socketMode, err)
+		}
+		opts = append(opts, WithUnixSocket(st.socketPath, os.FileMode(mode), st.socketOwner))
+	}
+	if st.noTCP {
+		if st.socketPath == "" {
+			return nil, errors.New("--no-tcp requires --socket")
+		}
+		opts = append(opts, WithoutTCP())
+	}
+	if st.tlsCert != "" || st.tlsKey != "" || st.tlsClientCA != "" {
+		certs, err := newCertReloader(st.tlsCert, st.tlsKey, st.tlsClientCA)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, WithTLS(certs))
+	}
+	return opts, nil
+}
+
+// tokens returns the auth tokens from the token settings and the token
+// file.
+func (st *settings) tokens() (*tokenSet, error) {
+	specs := append([]string(nil), st.tokenSpecs...)
+	if st.tokenFile != "" {
+		fileSpecs, err := loadTokenFile(st.tokenFile)
+		if err != nil {
+			return nil, err
+		}
+		specs = append(specs, fileSpecs...)
+	}
+	return buildTokenSet(specs)
+}
+
+// openStores opens the spool and the dead-letter file, if configured, and
+// returns the options that use them.
+func (st *settings) openStores() ([]Option, error) {
+	var opts []Option
+	if st.spoolDir != "" {
+		sp, err := openSpool(st.spoolDir, st.spoolBytes, st.spoolAge)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, WithSpool(sp))
+	}
+	if st.deadLetters != "" {
+		dl, err := openDeadLetterFile(st.deadLetters)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, WithDeadLetters(dl))
+	}
+	return opts, nil
+}
+
+// printEffective writes the settings as a config file, noting where each
+// came from. Token secrets are masked.
+func (st *settings) printEffective(w io.Writer) {
+	fmt.Fprintln(w, "# Effective configuration (flags > environment > config file > defaults)")
+	if st.configFile != "" {
+		fmt.Fprintf(w, "# Config file: %s\n", st.configFile)
+	}
+	st.flags.VisitAll(func(f *flag.Flag) {
+		if !configurable(f.Name) {
+			return
+		}
+		source := st.sources[f.Name]
+		if source == "" {
+			source = sourceDefault
+		}
+		fmt.Fprintf(w, "%s = %s  # %s\n", f.Name, formatConfigValue(f), source)
+	})
+}
+
+// formatConfigValue formats the value of a flag for the config file.
+func formatConfigValue(f *flag.Flag) string {
+	switch v := f.Value.(type) {
+	case *stringList:
+		quoted := make([]string, len(*v))
+		for i, s := range *v {
+			if f.Name == "token" {
+				// Bare secrets are masked too, keeping only a given name
+				s = "REDACTED"
+				if name, _, ok := strings.Cut((*v)[i], ":"); ok && name != "" {
+					s = name + ":REDACTED"
+				}
+			}
+			quoted[i] = strconv.Quote(s)
+		}
+		return "[" + strings.Join(quoted, ", ") + "]"
+	case flag.Getter:
+		switch v.Get().(type) {
+		case bool, int, int64, float64:
+			return v.String()
+		}
+	}
+	return strconv.Quote(f.Value.String())
+}
+
+// reloadable lists the settings a running server picks up when it reloads
+// its configuration. Changes to the others take effect on restart.
+var reloadable = map[string]bool{
+	"host":              true,
+	"port":              true,
+	"no-tcp":            true,
+	"http-port":         true,
+	"metrics-addr":      true,
+	"socket":            true,
+	"socket-mode":       true,
+	"socket-owner":      true,
+	"tls-cert":          true,
+	"tls-key":           true,
+	"tls-client-ca":     true,
+	"token":             true,
+	"token-file":        true,
+	"rate-limit":        true,
+	"rate-limit-client": true,
+	"backend":           true,
+	"retry-attempts":    true,
+	"retry-delay":       true,
+	"retry-jitter":      true,
+}
+
+// changedSettings returns the names of the settings whose values differ
+// between st and next. Values are compared unmasked, so a token whose
+// secret changed counts as a change.
+func (st *settings) changedSettings(next *settings) []string {
+	var changed []string
+	next.flags.VisitAll(func(f *flag.Flag) {
+		if !configurable(f.Name) {
+			return
+		}
+		if old := st.flags.Lookup(f.Name); old.Value.String() != f.Value.String() {
+			changed = append(changed, f.Name)
+		}
+	})
+	return changed
+}
+
+// reloadSettings loads the configuration again from args, the environment
+// and the config file, and applies it to the running server. A
+// configuration that fails to load or apply is rejected, leaving the
+// server as it was. It returns the settings in effect afterwards.
+func reloadSettings(server *Server, current *settings, args []string, getenv func(string) string) *settings {
+	log := server.log
+	next := &settings{}
+	fs := next.flagSet(current.flags.Name(), flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	if err := next.load(fs, args, getenv); err != nil {
+		log.Error("Configuration reload rejected, keeping the current configuration", "error", err)
+		return current
+	}
+
+	changed := make(map[string]bool)
+	var applied, pending []string
+	for _, name := range current.changedSettings(next) {
+		changed[name] = true
+		if reloadable[name] {
+			applied = append(applied, name)
+		} else {
+			pending = append(pending, name)
+		}
+	}
+
+	tokens := server.currentTokens()
+	opts, err := next.options()
+	// Keep the limiter's counts and the notifier when their settings are
+	// unchanged
+	if !changed["rate-limit"] && !changed["rate-limit-client"] {
+		opts = append(opts, WithRateLimit(server.currentLimiter()))
+	}
+	if err == nil && changed["backend"] {
+		var notifier Notifier
+		if notifier, err = NewNotifier(next.backend, NotifierOptions{Verbose: server.verbose}); err == nil {
+			if err = notifier.Available(); err == nil {
+				opts = append(opts, WithNotifier(notifier))
+			}
+		}
+	}
+	if err == nil {
+		err = server.Reconfigure(next.host, next.port, opts...)
+	}
+	if err != nil {
+		log.Error("Configuration reload rejected, keeping the current configuration", "error", err)
+		return current
+	}
+
+	// Token files are read again on every reload, so compare the tokens
+	// themselves rather than the settings naming them
+	attrs := []any{"changed", strings.Join(applied, ","), "tokens", server.currentTokens().len()}
+	added, removed, rotated := tokens.changes(server.currentTokens())
+	if len(added) > 0 {
+		attrs = append(attrs, "tokens_added", strings.Join(added, ","))
+	}
+	if len(removed) > 0 {
+		attrs = append(attrs, "tokens_removed", strings.Join(removed, ","))
+	}
+	if len(rotated) > 0 {
+		attrs = append(attrs, "tokens_rotated", strings.Join(rotated, ","))
+	}
+	log.Info("Configuration reloaded", attrs...)
+	if len(pending) > 0 {
+		log.Warn("Some changed settings take effect only after a restart", "settings", strings.Join(pending, ","))
+	}
+	return next
+}
+
+// runConfig implements the config subcommand, which checks or shows the
+// configuration the server would run with. It takes the server's flags.
+func runConfig(args []string) int {
+	usage := func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s config validate|print-effective [flags]\n", os.Args[0])
+	}
+	if len(args) == 0 {
+		usage()
+		return 2
+	}
+	cmd := args[0]
+	if cmd != "validate" && cmd != "print-effective" {
+		usage()
+		return 2
+	}
+
+	st := &settings{}
+	fs := st.flagSet("config "+cmd, flag.ContinueOnError)
+	if err := st.load(fs, args[1:], os.Getenv); err != nil {
+		if !errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		return 2
+	}
+
+	if cmd == "print-effective" {
+		st.printEffective(os.Stdout)
+		return 0
+	}
+
+	if _, _, err := st.logger(io.Discard); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if _, err := NewNotifier(st.backend, NotifierOptions{}); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if _, err := st.options(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if st.configFile != "" {
+		fmt.Printf("Configuration is valid (config file %s)\n", st.configFile)
+	} else {
+		fmt.Println("Configuration is valid (no config file)")
+	}
+	return 0
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    map[string][]string
+		wantErr string
+	}{
+		{
+			name: "scalars and comments",
+			input: `# comment
+port = 8080
+host = "127.0.0.1" # trailing comment
+async = true
+coalesce-template = 'raw {{.Count}} # not a comment'
+retry_delay = "2s"
+`,
+			want: map[string][]string{
+				"port":              {"8080"},
+				"host":              {"127.0.0.1"},
+				"async":             {"true"},
+				"coalesce-template": {"raw {{.Count}} # not a comment"},
+				"retry-delay":       {"2s"},
+			},
+		},
+		{
+			name: "sections and arrays",
+			input: `token = ["ci:s3cret", "vm1:0th3r"]
+[tls]
+cert = "/etc/cert.pem"
+[quiet]
+hours = [
+  "Mon,Wed 22:00-07:00", # evenings
+  "Sat 00:00-23:59",
+]
+`,
+			want: map[string][]string{
+				"token":       {"ci:s3cret", "vm1:0th3r"},
+				"tls-cert":    {"/etc/cert.pem"},
+				"quiet-hours": {"Mon,Wed 22:00-07:00", "Sat 00:00-23:59"},
+			},
+		},
+		{
+			name:  "escapes",
+			input: `coalesce-template = "a\tb \"q\""`,
+			want:  map[string][]string{"coalesce-template": {"a\tb \"q\""}},
+		},
+		{"missing equals", "port 8080", nil, "1: expected key = value"},
+		{"missing value", "port =", nil, "1: port: missing value"},
+		{"unterminated string", `host = "localhost`, nil, "1: host: unterminated string"},
+		{"unterminated array", "token = [\"a:b\",\n", nil, "1: unterminated array"},
+		{"trailing garbage", `host = "a" "b"`, nil, `1: host: unexpected "\"b\"" after value`},
+		{"duplicate", "port = 1\nport = 2", nil, "2: port set more than once"},
+		{"bad section", "[tls", nil, `1: invalid section header "[tls"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, err := parseConfig(strings.NewReader(tt.input))
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("expected error %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			got := make(map[string][]string)
+			for key, e := range entries {
+				got[key] = e.values
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+// loadTestSettings loads settings from args, env and, unless it is empty,
+// a config file with the given contents. No default config file is found.
+func loadTestSettings(t *testing.T, args []string, env map[string]string, file string) (*settings, *flag.FlagSet, error) {
+	t.Helper()
+	dir := t.TempDir()
+	getenv := func(key string) string {
+		if key == "XDG_CONFIG_HOME" {
+			return dir
+		}
+		return env[key]
+	}
+	if file != "" {
+		path := filepath.Join(dir, "test.toml")
+		if err := os.WriteFile(path, []byte(file), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		args = append([]string{"--config", path}, args...)
+	}
+
+	st := &settings{}
+	fs := st.flagSet("test", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	return st, fs, st.load(fs, args, getenv)
+}
+
+func TestLoadSettingsPrecedence(t *testing.T) {
+	file := `port = 1000
+http-port = 1001
+host = "127.0.0.1"
+token = ["file:secret"]
+[history]
+size = 10
+`
+	env := map[string]string{
+		"MNB_PORT":      "2000",
+		"MNB_HTTP_PORT": "2001",
+		"MNB_TOKENS":    "env1:a, env2:b",
+	}
+	st, _, err := loadTestSettings(t, []string{"-p", "3000"}, env, file)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if st.port != 3000 || st.sources["port"] != sourceFlag {
+		t.Errorf("expected the flag to win, got %d from %q", st.port, st.sources["port"])
+	}
+	if st.httpPort != 2001 || st.sources["http-port"] != "env MNB_HTTP_PORT" {
+		t.Errorf("expected the environment to beat the file, got %d from %q", st.httpPort, st.sources["http-port"])
+	}
+	if st.host != "127.0.0.1" || st.historySize != 10 {
+		t.Errorf("expected settings from the file, got host %q and history size %d", st.host, st.historySize)
+	}
+	if !reflect.DeepEqual([]string(st.tokenSpecs), []string{"env1:a", "env2:b"}) {
+		t.Errorf("expected tokens from MNB_TOKENS only, got %v", st.tokenSpecs)
+	}
+	if st.workers != defaultWorkers || st.sources["workers"] != "" {
+		t.Errorf("expected the default worker count, got %d", st.workers)
+	}
+
+	// PORT is still honoured below MNB_PORT
+	st, _, err = loadTestSettings(t, nil, map[string]string{"PORT": "4000"}, "")
+	if err != nil || st.port != 4000 {
+		t.Errorf("expected port 4000 from PORT, got %d (%v)", st.port, err)
+	}
+}
+
+func TestLoadSettingsInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		args    []string
+		env     map[string]string
+		file    string
+		wantErr string
+	}{
+		{"non-numeric PORT", nil, map[string]string{"PORT": "http"}, "", `invalid PORT "http"`},
+		{"invalid env duration", nil, map[string]string{"MNB_DEDUP_WINDOW": "soon"}, "", `invalid MNB_DEDUP_WINDOW "soon"`},
+		{"unknown setting", nil, nil, "colour = \"blue\"", `:1: unknown setting "colour"`},
+		{"short flag in file", nil, nil, "p = 1", `:1: unknown setting "p"`},
+		{"invalid file value", nil, nil, "\nworkers = \"many\"", `:2: invalid workers "many"`},
+		{"array for single value", nil, nil, "host = [\"a\", \"b\"]", ":1: host takes a single value"},
+		{"missing config file", []string{"--config", "/nonexistent/config.toml"}, nil, "", "failed to read config file"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := loadTestSettings(t, tt.args, tt.env, tt.file)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestSettingsLogger(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		env  map[string]string
+		want slog.Level
+	}{
+		{"default", nil, nil, slog.LevelInfo},
+		{"verbose", []string{"-v"}, nil, slog.LevelDebug},
+		{"verbose from env", nil, map[string]string{"MNB_VERBOSE": "true"}, slog.LevelDebug},
+		{"level beats verbose", []string{"-v", "--log-level", "warn"}, nil, slog.LevelWarn},
+		{"level from env beats verbose", []string{"-v"}, map[string]string{"MNB_LOG_LEVEL": "error"}, slog.LevelError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st, _, err := loadTestSettings(t, tt.args, tt.env, "")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			_, level, err := st.logger(io.Discard)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if level != tt.want {
+				t.Errorf("expected level %v, got %v", tt.want, level)
+			}
+		})
+	}
+}
+
+func TestPrintEffective(t *testing.T) {
+	file := `quiet-hours = ["Mon,Wed 22:00-07:00"]
+token = ["ci:s3cret"]
+`
+	env := map[string]string{"MNB_RETRY_DELAY": "3s"}
+	st, _, err := loadTestSettings(t, []string{"--port", "7000"}, env, file)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var out bytes.Buffer
+	st.printEffective(&out)
+	got := out.String()
+	for _, want := range []string{
+		"port = 7000  # flag\n",
+		"retry-delay = \"3s\"  # env MNB_RETRY_DELAY\n",
+		"token = [\"ci:REDACTED\"]  # file\n",
+		"async = false  # default\n",
+		"retry-jitter = 0.2  # default\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("expected output to contain %q, got:\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, "s3cret") {
+		t.Error("expected token secrets to be masked")
+	}
+
+	// The output is itself a valid config file
+	entries, err := parseConfig(strings.NewReader(got))
+	if err != nil {
+		t.Fatalf("expected output to parse, got %v", err)
+	}
+	if v := entries["retry-delay"].values; len(v) != 1 || v[0] != "3s" {
+		t.Errorf("expected retry-delay 3s, got %v", v)
+	}
+	if v := entries["quiet-hours"].values; len(v) != 1 || v[0] != "Mon,Wed 22:00-07:00" {
+		t.Errorf("expected quiet hours to survive, got %v", v)
+	}
+
+	// Secrets without a name, as usually given in MNB_TOKENS, are masked too
+	env = map[string]string{"MNB_TOKENS": "barelysecret,:alsosecret,vm:0th3r"}
+	st, _, err = loadTestSettings(t, nil, env, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	out.Reset()
+	st.printEffective(&out)
+	got = out.String()
+	if want := "token = [\"REDACTED\", \"REDACTED\", \"vm:REDACTED\"]  # env MNB_TOKENS\n"; !strings.Contains(got, want) {
+		t.Errorf("expected output to contain %q, got:\n%s", want, got)
+	}
+	for _, secret := range []string{"barelysecret", "alsosecret", "0th3r"} {
+		if strings.Contains(got, secret) {
+			t.Errorf("expected secret %q to be masked, got:\n%s", secret, got)
+		}
+	}
+}
+
+func TestSettingsOptions(t *testing.T) {
+	st, _, err := loadTestSettings(t, nil, nil, "no-tcp = true\n")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := st.options(); err == nil || err.Error() != "--no-tcp requires --socket" {
+		t.Errorf("expected --no-tcp error, got %v", err)
+	}
+
+	st, _, err = loadTestSettings(t, []string{"--async", "--dedup-window", "1m"}, map[string]string{"MNB_TOKEN": "ci:s3cret"}, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	opts, err := st.options()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	s := NewServer(st.host, st.port, false, append(opts, WithNotifier(&fakeNotifier{}))...)
+	t.Cleanup(s.Stop)
+	if !s.async || s.tokens.len() != 1 || s.dedup == nil {
+		t.Errorf("expected async, one token and deduplication, got %+v", s)
+	}
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+)
+
+// deadLetter is a notification that could not be delivered after every
+// retry.
+type deadLetter struct {
+	ID       string              `json:"id"`
+	FailedAt time.Time           `json:"failed_at"`
+	Attempts int                 `json:"attempts"`
+	Error    string              `json:"error"`
+	Request  NotificationRequest `json:"request"`
+}
+
+// deadLetterFile is an append-only file of dead letters, one JSON object per
+// line. Writers take an exclusive lock on the file so the server and the
+// dead-letter subcommand can share it.
+type deadLetterFile struct {
+	path string
+}
+
+// openDeadLetterFile creates the dead-letter file at path if it does not
+// exist yet.
+func openDeadLetterFile(path string) (*deadLetterFile, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create dead-letter directory: %w", err)
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open dead-letter file: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return nil, fmt.Errorf("failed to open dead-letter file: %w", err)
+	}
+	return &deadLetterFile{path: path}, nil
+}
+
+// WithDeadLetters moves notifications that fail every delivery attempt to
+// dl instead of leaving them in the spool.
+func WithDeadLetters(dl *deadLetterFile) Option {
+	return func(s *Server) {
+		s.deadLetters = dl
+	}
+}
+
+// add appends entry to the file.
+func (dl *deadLetterFile) add(entry deadLetter) error {
+	// Never persist credentials
+	entry.Request.Token = ""
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to encode dead letter: %w", err)
+	}
+
+	f, err := dl.lock(os.O_CREATE | os.O_WRONLY | os.O_APPEND)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write dead letter: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("failed to sync dead-letter file: %w", err)
+	}
+	return nil
+}
+
+// list returns every dead letter in the file, oldest first.
+func (dl *deadLetterFile) list() ([]deadLetter, error) {
+	f, err := dl.lock(os.O_RDONLY)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+	return readDeadLetters(f)
+}
+
+// remove deletes the dead letters with the given IDs, keeping the rest and
+// anything appended in the meantime.
+func (dl *deadLetterFile) remove(ids []string) error {
+	f, err := dl.lock(os.O_RDWR)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+
+	entries, err := readDeadLetters(f)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, entry := range entries {
+		if slices.Contains(ids, entry.ID) {
+			continue
+		}
+		if err := enc.Encode(entry); err != nil {
+			return fmt.Errorf("failed to encode dead letter: %w", err)
+		}
+	}
+
+	// Rewrite in place so the lock other writers wait on stays valid
+	if err := f.Truncate(0); err != nil {
+		return fmt.Errorf("failed to truncate dead-letter file: %w", err)
+	}
+	if _, err := f.WriteAt(buf.Bytes(), 0); err != nil {
+		return fmt.Errorf("failed to write dead-letter file: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("failed to sync dead-letter file: %w", err)
+	}
+	return nil
+}
+
+// lock opens the file with flag and takes an exclusive lock on it, released
+// when the file is closed.
+func (dl *deadLetterFile) lock(flag int) (*os.File, error) {
+	f, err := os.OpenFile(dl.path, flag, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open dead-letter file: %w", err)
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("failed to lock dead-letter file: %w", err)
+	}
+	return f, nil
+}
+
+func readDeadLetters(r io.Reader) ([]deadLetter, error) {
+	var entries []deadLetter
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxHTTPBodySize+4096)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var entry deadLetter
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("dead-letter file line %d: %w", line, err)
+		}
+		entries = append(entries, entry)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read dead-letter file: %w", err)
+	}
+	return entries, nil
+}
+
+// addDeadLetter records a notification that failed every attempt. Once it
+// is safely in the dead-letter file it no longer needs to be spooled.
+func (s *Server) addDeadLetter(id string, req NotificationRequest, attempts int, cause error) {
+	if s.deadLetters == nil {
+		return
+	}
+	entry := deadLetter{ID: id, FailedAt: time.Now(), Attempts: attempts, Error: cause.Error(), Request: req}
+	if err := s.deadLetters.add(entry); err != nil {
+		s.log.Error("Error writing dead letter", "request_id", id, "error", err)
+		return
+	}
+	s.log.Warn("Moved notification to dead-letter file", "request_id", id, "attempts", attempts, "error", cause)
+	if s.spool != nil {
+		s.spool.remove(id)
+	}
+}
+
+// runDeadLetter implements the dead-letter subcommand, which lists dead
+// letters or sends them to a running server again.
+func runDeadLetter(args []string) int {
+	usage := "usage: macos-notify-bridge dead-letter list|redrive -file PATH [flags]"
+	if len(args) == 0 || (args[0] != "list" && args[0] != "redrive") {
+		fmt.Fprintln(os.Stderr, usage)
+		return 2
+	}
+
+	fs := flag.NewFlagSet("dead-letter "+args[0], flag.ContinueOnError)
+	path := fs.String("file", "", "Dead-letter file")
+	var ids stringList
+	fs.Var(&ids, "id", "Only re-drive the dead letter with this ID (repeatable)")
+	client := addClientFlags(fs)
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+	if *path == "" {
+		fmt.Fprintln(os.Stderr, "dead-letter: -file is required")
+		return 2
+	}
+	dl := &deadLetterFile{path: *path}
+
+	entries, err := dl.list()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "dead-letter: %v\n", err)
+		return 1
+	}
+
+	if args[0] == "list" {
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tFAILED AT\tATTEMPTS\tTITLE\tERROR")
+		for _, e := range entries {
+			fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\n", e.ID, e.FailedAt.Local().Format(time.DateTime), e.Attempts, e.Request.Title, e.Error)
+		}
+		if err := w.Flush(); err != nil {
+			return 1
+		}
+		return 0
+	}
+
+	var sent []string
+	failed := 0
+	for _, e := range entries {
+		if len(ids) > 0 && !slices.Contains(ids, e.ID) {
+			continue
+		}
+		resp, err := client.send(e.Request)
+		if err == nil && !strings.HasPrefix(resp, "OK") && !strings.HasPrefix(resp, "QUEUED") && resp != "EXPIRED" {
+			err = fmt.Errorf("%s", resp)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", e.ID, err)
+			failed++
+			continue
+		}
+		fmt.Printf("%s: %s\n", e.ID, resp)
+		sent = append(sent, e.ID)
+	}
+
+	if len(sent) > 0 {
+		if err := dl.remove(sent); err != nil {
+			fmt.Fprintf(os.Stderr, "dead-letter: %v\n", err)
+			return 1
+		}
+	}
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
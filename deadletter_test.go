@@ -0,0 +1,89 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDeadLetterFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dead-letter.jsonl")
+	dl, err := openDeadLetterFile(path)
+	if err != nil {
+		t.Fatalf("failed to open dead-letter file: %v", err)
+	}
+
+	for _, id := range []string{"a", "b", "c"} {
+		entry := deadLetter{ID: id, FailedAt: time.Now(), Attempts: 3, Error: "exit status 1",
+			Request: NotificationRequest{Title: id, Message: "M", Token: "s3cret"}}
+		if err := dl.add(entry); err != nil {
+			t.Fatalf("failed to add dead letter: %v", err)
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "s3cret") {
+		t.Errorf("expected token not to be persisted, got %s", data)
+	}
+
+	if err := dl.remove([]string{"b"}); err != nil {
+		t.Fatalf("failed to remove dead letter: %v", err)
+	}
+	entries, err := dl.list()
+	if err != nil {
+		t.Fatalf("failed to list dead letters: %v", err)
+	}
+	if len(entries) != 2 || entries[0].ID != "a" || entries[1].ID != "c" {
+		t.Errorf("expected dead letters a and c, got %+v", entries)
+	}
+}
+
+func TestDeadLetterRedrive(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dead-letter.jsonl")
+	dl, err := openDeadLetterFile(path)
+	if err != nil {
+		t.Fatalf("failed to open dead-letter file: %v", err)
+	}
+	for _, id := range []string{"keep", "redrive"} {
+		// The redriven notification was first sent by a protocol version 2 client
+		req := NotificationRequest{Title: id, Message: "M", Version: protocolV2}
+		if err := dl.add(deadLetter{ID: id, Request: req}); err != nil {
+			t.Fatalf("failed to add dead letter: %v", err)
+		}
+	}
+
+	tokens, err := newTokenSet(map[string]string{"cli": "s3cret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake := &fakeNotifier{}
+	s := NewServer("localhost", 0, false, WithNotifier(fake), WithTokens(tokens))
+	runServer(t, s)
+	addr := s.Addr().String()
+
+	// Without the token the server refuses and the dead letter is kept
+	if code := runDeadLetter([]string{"redrive", "-file", path, "-addr", addr, "-id", "redrive"}); code != 1 {
+		t.Errorf("expected exit code 1 without token, got %d", code)
+	}
+
+	if code := runDeadLetter([]string{"redrive", "-file", path, "-addr", addr, "-token", "s3cret", "-id", "redrive"}); code != 0 {
+		t.Fatalf("expected exit code 0, got %d", code)
+	}
+	reqs := fake.requests()
+	if len(reqs) != 1 || reqs[0].Title != "redrive" {
+		t.Errorf("expected only the selected dead letter to be delivered, got %+v", reqs)
+	}
+
+	entries, err := dl.list()
+	if err != nil {
+		t.Fatalf("failed to list dead letters: %v", err)
+	}
+	if len(entries) != 1 || entries[0].ID != "keep" {
+		t.Errorf("expected only the unselected dead letter to remain, got %+v", entries)
+	}
+}
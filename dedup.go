@@ -0,0 +1,121 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// maxDedupKeyLength caps the length of a client-supplied dedup_key.
+const maxDedupKeyLength = 256
+
+// dedupEntry remembers the notification a fingerprint was first seen with.
+type dedupEntry struct {
+	id         string
+	seen       time.Time
+	suppressed int
+}
+
+// deduplicator suppresses notifications identical to one accepted within
+// the last window.
+type deduplicator struct {
+	window time.Duration
+	now    func() time.Time
+
+	mu        sync.Mutex
+	entries   map[string]*dedupEntry
+	lastSweep time.Time
+}
+
+func newDeduplicator(window time.Duration) *deduplicator {
+	return &deduplicator{
+		window:  window,
+		now:     time.Now,
+		entries: make(map[string]*dedupEntry),
+	}
+}
+
+// WithDedupWindow answers repeats of a notification accepted within window
+// with OK duplicate instead of delivering them again. A zero window turns
+// duplicate suppression off.
+func WithDedupWindow(window time.Duration) Option {
+	return func(s *Server) {
+		if window > 0 {
+			s.dedup = newDeduplicator(window)
+		} else {
+			s.dedup = nil
+		}
+	}
+}
+
+// fingerprint identifies notifications that count as repeats of each
+// other: those with the same dedup_key or, without one, the same title,
+// message, subtitle and group.
+func fingerprint(req NotificationRequest) string {
+	if req.DedupKey != "" {
+		return "key:" + req.DedupKey
+	}
+	h := sha256.New()
+	for _, field := range []string{req.Title, req.Message, req.Subtitle, req.Group} {
+		h.Write([]byte(field))
+		h.Write([]byte{0})
+	}
+	return "sum:" + hex.EncodeToString(h.Sum(nil))
+}
+
+// check records id under key, unless key was already seen within the
+// window. In that case it returns the earlier notification's entry and
+// true.
+func (d *deduplicator) check(key, id string) (dedupEntry, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := d.now()
+	if now.Sub(d.lastSweep) > d.window {
+		for k, e := range d.entries {
+			if now.Sub(e.seen) > d.window {
+				delete(d.entries, k)
+			}
+		}
+		d.lastSweep = now
+	}
+
+	if e, ok := d.entries[key]; ok && now.Sub(e.seen) <= d.window {
+		e.suppressed++
+		return *e, true
+	}
+	d.entries[key] = &dedupEntry{id: id, seen: now}
+	return dedupEntry{}, false
+}
+
+// forget drops key if it still refers to id, so a notification that could
+// not be delivered does not suppress the client's next attempt.
+func (d *deduplicator) forget(key, id string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if e, ok := d.entries[key]; ok && e.id == id {
+		delete(d.entries, key)
+	}
+}
+
+// isDuplicate reports whether req repeats a notification accepted within
+// the dedup window, returning that notification's ID. Otherwise req is
+// remembered under id.
+func (s *Server) isDuplicate(req NotificationRequest, id string) (string, bool) {
+	if s.dedup == nil {
+		return "", false
+	}
+	original, dup := s.dedup.check(fingerprint(req), id)
+	if dup {
+		s.log.Debug("Suppressed duplicate notification", "request_id", original.id, "suppressed", original.suppressed)
+	}
+	return original.id, dup
+}
+
+// forgetDuplicate stops req from suppressing repeats after it failed.
+func (s *Server) forgetDuplicate(req NotificationRequest, id string) {
+	if s.dedup != nil {
+		s.dedup.forget(fingerprint(req), id)
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFingerprint(t *testing.T) {
+	base := NotificationRequest{Title: "Build", Message: "Done", Subtitle: "main", Group: "ci"}
+
+	tests := []struct {
+		name string
+		req  NotificationRequest
+		same bool
+	}{
+		{"identical", base, true},
+		{"different sound", NotificationRequest{Title: "Build", Message: "Done", Subtitle: "main", Group: "ci", Sound: "Hero"}, true},
+		{"different message", NotificationRequest{Title: "Build", Message: "Failed", Subtitle: "main", Group: "ci"}, false},
+		{"different subtitle", NotificationRequest{Title: "Build", Message: "Done", Subtitle: "dev", Group: "ci"}, false},
+		{"different group", NotificationRequest{Title: "Build", Message: "Done", Subtitle: "main"}, false},
+		{"fields shifted", NotificationRequest{Title: "BuildDone", Subtitle: "main", Group: "ci"}, false},
+		{"dedup key", NotificationRequest{Title: "Build", Message: "Done", Subtitle: "main", Group: "ci", DedupKey: "job-1"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := fingerprint(tt.req) == fingerprint(base); got != tt.same {
+				t.Errorf("expected same fingerprint %v, got %v", tt.same, got)
+			}
+		})
+	}
+
+	a := NotificationRequest{Title: "A", Message: "1", DedupKey: "job-1"}
+	b := NotificationRequest{Title: "B", Message: "2", DedupKey: "job-1"}
+	if fingerprint(a) != fingerprint(b) {
+		t.Error("expected requests with the same dedup_key to share a fingerprint")
+	}
+}
+
+func TestDeduplicatorWindow(t *testing.T) {
+	now := time.Now()
+	d := newDeduplicator(10 * time.Second)
+	d.now = func() time.Time { return now }
+
+	if _, dup := d.check("k", "first"); dup {
+		t.Fatal("expected first notification not to be a duplicate")
+	}
+	now = now.Add(5 * time.Second)
+	e, dup := d.check("k", "second")
+	if !dup || e.id != "first" || e.suppressed != 1 {
+		t.Errorf("expected duplicate of first, got %+v, %v", e, dup)
+	}
+
+	now = now.Add(6 * time.Second)
+	if _, dup := d.check("k", "third"); dup {
+		t.Error("expected notification after the window not to be a duplicate")
+	}
+
+	d.forget("k", "other")
+	if _, dup := d.check("k", "fourth"); !dup {
+		t.Error("expected forget with another ID to keep the entry")
+	}
+	d.forget("k", "third")
+	if _, dup := d.check("k", "fifth"); dup {
+		t.Error("expected forgotten entry not to suppress")
+	}
+}
+
+func TestDuplicateSuppression(t *testing.T) {
+	fake := &fakeNotifier{}
+	s := NewServer("localhost", 0, false, WithNotifier(fake), WithDedupWindow(time.Minute), WithRetry(1, 0, 0))
+	t.Cleanup(s.Stop)
+
+	line := `{"title":"Build","message":"Done"}`
+	if got := roundTrip(t, s, line); got != "OK" {
+		t.Fatalf("expected OK, got %q", got)
+	}
+	if got := roundTrip(t, s, line); got != "OK duplicate" {
+		t.Errorf("expected OK duplicate, got %q", got)
+	}
+	if got := roundTrip(t, s, `{"title":"Build","message":"Failed"}`); got != "OK" {
+		t.Errorf("expected OK for a different message, got %q", got)
+	}
+	if got := len(fake.requests()); got != 2 {
+		t.Errorf("expected 2 delivered notifications, got %d", got)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/notify", strings.NewReader(line))
+	rec := httptest.NewRecorder()
+	s.httpHandler().ServeHTTP(rec, req)
+	var resp NotificationResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if rec.Code != http.StatusOK || resp.Status != "duplicate" || resp.ID == "" {
+		t.Errorf("expected duplicate response with the original ID, got %d %+v", rec.Code, resp)
+	}
+}
+
+func TestDuplicateOfFailedNotificationIsRetried(t *testing.T) {
+	fake := &fakeNotifier{err: errors.New("backend down")}
+	s := NewServer("localhost", 0, false, WithNotifier(fake), WithDedupWindow(time.Minute), WithRetry(1, 0, 0))
+	t.Cleanup(s.Stop)
+
+	line := `{"title":"Build","message":"Done"}`
+	if got := roundTrip(t, s, line); got != "ERROR: backend down" {
+		t.Fatalf("expected backend error, got %q", got)
+	}
+
+	fake.mu.Lock()
+	fake.err = nil
+	fake.mu.Unlock()
+	if got := roundTrip(t, s, line); got != "OK" {
+		t.Errorf("expected failed notification not to suppress a retry, got %q", got)
+	}
+}
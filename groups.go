@@ -0,0 +1,89 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// errUnsupported is returned when the notifier backend cannot perform an
+// action.
+var errUnsupported = errors.New("not supported by backend")
+
+// DeliveredNotification describes a notification currently shown in
+// Notification Center.
+type DeliveredNotification struct {
+	Group       string `json:"group"`
+	Title       string `json:"title"`
+	Subtitle    string `json:"subtitle,omitempty"`
+	Message     string `json:"message"`
+	DeliveredAt string `json:"delivered_at"`
+}
+
+// GroupRemover is implemented by backends that can withdraw delivered
+// notifications by group.
+type GroupRemover interface {
+	RemoveGroup(group string) error
+}
+
+// GroupLister is implemented by backends that can report the delivered
+// notifications in a group.
+type GroupLister interface {
+	ListGroup(group string) ([]DeliveredNotification, error)
+}
+
+// removeGroup clears every delivered notification in group.
+func (s *Server) removeGroup(group string) error {
+	notifier := s.currentNotifier()
+	remover, ok := notifier.(GroupRemover)
+	if !ok {
+		return fmt.Errorf("remove %w %s", errUnsupported, notifier.Name())
+	}
+	if err := remover.RemoveGroup(group); err != nil {
+		s.log.Debug("Error removing group", "group", group, "error", err)
+		return err
+	}
+	s.log.Debug("Removed notifications in group", "group", group)
+	return nil
+}
+
+// listGroup returns the delivered notifications in group.
+func (s *Server) listGroup(group string) ([]DeliveredNotification, error) {
+	notifier := s.currentNotifier()
+	lister, ok := notifier.(GroupLister)
+	if !ok {
+		return nil, fmt.Errorf("list %w %s", errUnsupported, notifier.Name())
+	}
+	notifications, err := lister.ListGroup(group)
+	if err != nil {
+		s.log.Debug("Error listing group", "group", group, "error", err)
+		return nil, err
+	}
+	if notifications == nil {
+		notifications = []DeliveredNotification{}
+	}
+	return notifications, nil
+}
+
+// parseNotificationList parses the tab-separated table printed by
+// terminal-notifier -list, skipping its header row.
+func parseNotificationList(output string) []DeliveredNotification {
+	var notifications []DeliveredNotification
+	for _, line := range strings.Split(output, "\n") {
+		if line == "" || strings.HasPrefix(line, "GroupID\t") {
+			continue
+		}
+		fields := strings.Split(line, "\t")
+		for len(fields) < 5 {
+			fields = append(fields, "")
+		}
+		notifications = append(notifications, DeliveredNotification{
+			Group:       fields[0],
+			Title:       fields[1],
+			Subtitle:    fields[2],
+			Message:     fields[3],
+			DeliveredAt: fields[4],
+		})
+	}
+	return notifications
+}
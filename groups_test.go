@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// groupNotifier is a fakeNotifier that also supports group operations.
+type groupNotifier struct {
+	fakeNotifier
+	removed []string
+	listed  []DeliveredNotification
+}
+
+func (g *groupNotifier) RemoveGroup(group string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.removed = append(g.removed, group)
+	return nil
+}
+
+func (g *groupNotifier) ListGroup(group string) ([]DeliveredNotification, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	var out []DeliveredNotification
+	for _, n := range g.listed {
+		if n.Group == group {
+			out = append(out, n)
+		}
+	}
+	return out, nil
+}
+
+func TestParseNotificationList(t *testing.T) {
+	output := "GroupID\tTitle\tSubtitle\tMessage\tDelivered At\n" +
+		"ci\tBuild\t(null)\tRunning\t2026-10-16 08:00:00 +0000\n" +
+		"ci\tDeploy\tprod\tDone\t2026-10-16 08:05:00 +0000\n"
+
+	got := parseNotificationList(output)
+	if len(got) != 2 {
+		t.Fatalf("expected 2 notifications, got %d: %+v", len(got), got)
+	}
+	want := DeliveredNotification{Group: "ci", Title: "Deploy", Subtitle: "prod", Message: "Done", DeliveredAt: "2026-10-16 08:05:00 +0000"}
+	if got[1] != want {
+		t.Errorf("expected %+v, got %+v", want, got[1])
+	}
+
+	if got := parseNotificationList(""); len(got) != 0 {
+		t.Errorf("expected no notifications for empty output, got %+v", got)
+	}
+}
+
+func TestGroupActionsOverTCP(t *testing.T) {
+	backend := &groupNotifier{listed: []DeliveredNotification{
+		{Group: "ci", Title: "Build", Message: "Running"},
+		{Group: "other", Title: "Other", Message: "Hidden"},
+	}}
+	s := NewServer("localhost", 0, false, WithNotifier(backend))
+
+	if got := roundTrip(t, s, `{"action":"remove","group":"ci"}`); got != "OK" {
+		t.Errorf("expected OK for remove, got %q", got)
+	}
+	if len(backend.removed) != 1 || backend.removed[0] != "ci" {
+		t.Errorf("expected group ci to be removed, got %v", backend.removed)
+	}
+
+	got := roundTrip(t, s, `{"action":"list","group":"ci"}`)
+	data, ok := strings.CutPrefix(got, "OK ")
+	if !ok {
+		t.Fatalf("expected OK with JSON for list, got %q", got)
+	}
+	var listed []DeliveredNotification
+	if err := json.Unmarshal([]byte(data), &listed); err != nil {
+		t.Fatalf("failed to decode list %q: %v", data, err)
+	}
+	if len(listed) != 1 || listed[0].Title != "Build" {
+		t.Errorf("expected only the ci notification, got %+v", listed)
+	}
+
+	if got := roundTrip(t, s, `{"action":"list","group":"empty"}`); got != "OK []" {
+		t.Errorf("expected empty list, got %q", got)
+	}
+	if got := roundTrip(t, s, `{"action":"remove"}`); got != "ERROR: missing group" {
+		t.Errorf("expected missing group error, got %q", got)
+	}
+	if got := roundTrip(t, s, `{"action":"explode","group":"ci"}`); !strings.HasPrefix(got, "ERROR: invalid action") {
+		t.Errorf("expected unknown action error, got %q", got)
+	}
+}
+
+func TestGroupActionsUnsupported(t *testing.T) {
+	s := NewServer("localhost", 0, false, WithNotifier(&fakeNotifier{}))
+
+	if got := roundTrip(t, s, `{"action":"remove","group":"ci"}`); got != "ERROR: remove not supported by backend fake" {
+		t.Errorf("expected unsupported error, got %q", got)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/notify", strings.NewReader(`{"action":"list","group":"ci"}`))
+	rec := httptest.NewRecorder()
+	s.httpHandler().ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotImplemented {
+		t.Errorf("expected status %d, got %d", http.StatusNotImplemented, rec.Code)
+	}
+}
+
+func TestGroupListOverHTTP(t *testing.T) {
+	backend := &groupNotifier{listed: []DeliveredNotification{{Group: "ci", Title: "Build", Message: "Running"}}}
+	s := NewServer("localhost", 0, false, WithNotifier(backend))
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/notify", strings.NewReader(`{"action":"list","group":"ci"}`))
+	rec := httptest.NewRecorder()
+	s.httpHandler().ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rec.Code)
+	}
+	var resp struct {
+		Status string                  `json:"status"`
+		Result []DeliveredNotification `json:"result"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if resp.Status != "ok" || len(resp.Result) != 1 || resp.Result[0].Title != "Build" {
+		t.Errorf("unexpected response: %s", rec.Body.String())
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// backendProbeInterval is how long the server waits before checking an
+// unavailable backend again.
+const backendProbeInterval = 5 * time.Second
+
+// Exit codes of the healthcheck subcommand.
+const (
+	healthOK          = 0
+	healthUnreachable = 1
+	healthUsage       = 2
+	healthNotReady    = 3
+)
+
+// pingResult is the response to a ping.
+type pingResult struct {
+	Version       string `json:"version"`
+	Uptime        string `json:"uptime"`
+	UptimeSeconds int64  `json:"uptime_seconds"`
+	Ready         bool   `json:"ready"`
+}
+
+// ping reports the server's version, how long it has been running and
+// whether it is ready to deliver notifications.
+func (s *Server) ping() pingResult {
+	uptime := time.Since(s.started).Round(time.Second)
+	return pingResult{
+		Version:       version,
+		Uptime:        uptime.String(),
+		UptimeSeconds: int64(uptime.Seconds()),
+		Ready:         s.readiness() == nil,
+	}
+}
+
+// readiness returns why the server cannot deliver notifications yet, or
+// nil if it can: its listeners are open and the backend has passed its
+// availability check.
+func (s *Server) readiness() error {
+	select {
+	case <-s.shutdown:
+		return errors.New("shutting down")
+	default:
+	}
+	select {
+	case <-s.ready:
+	default:
+		return errors.New("starting")
+	}
+	if !s.backendReady.Load() {
+		return errors.New("backend unavailable")
+	}
+	return nil
+}
+
+// probeBackend checks that the notifier can deliver notifications, trying
+// again every backendProbeInterval until it can or the server stops, and
+// marks the server ready once it passes.
+func (s *Server) probeBackend() {
+	for {
+		notifier := s.currentNotifier()
+		err := notifier.Available()
+		if err == nil {
+			s.backendReady.Store(true)
+			s.log.Debug("Backend available", "backend", notifier.Name())
+			return
+		}
+		s.log.Warn("Backend unavailable, not ready", "backend", notifier.Name(), "error", err)
+
+		select {
+		case <-time.After(backendProbeInterval):
+		case <-s.shutdown:
+			return
+		}
+	}
+}
+
+// handleHealthz reports that the server is alive, along with its version
+// and uptime.
+func (s *Server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		s.writeJSON(w, http.StatusMethodNotAllowed, NotificationResponse{Status: "error", Error: "method not allowed"})
+		return
+	}
+	s.writeJSON(w, http.StatusOK, NotificationResponse{Status: statusOK, Result: s.ping()})
+}
+
+// handleReadyz reports whether the server is ready to deliver
+// notifications, answering 503 until it is.
+func (s *Server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		s.writeJSON(w, http.StatusMethodNotAllowed, NotificationResponse{Status: "error", Error: "method not allowed"})
+		return
+	}
+	if err := s.readiness(); err != nil {
+		s.writeJSON(w, http.StatusServiceUnavailable, NotificationResponse{Status: "error", Error: err.Error()})
+		return
+	}
+	s.writeJSON(w, http.StatusOK, NotificationResponse{Status: statusOK})
+}
+
+// runHealthcheck implements the healthcheck subcommand, which pings a
+// running server and exits with healthOK if it is ready, healthNotReady if
+// it is alive but not ready, and healthUnreachable if it cannot be reached
+// or does not answer the ping.
+func runHealthcheck(args []string) int {
+	fs := flag.NewFlagSet("healthcheck", flag.ContinueOnError)
+	quiet := fs.Bool("quiet", false, "Print nothing, only exit with the status")
+	client := addClientFlags(fs)
+	// A health check should give up well before the default client timeout
+	timeout := fs.Lookup("timeout")
+	timeout.DefValue = "5s"
+	_ = timeout.Value.Set(timeout.DefValue)
+	if err := fs.Parse(args); err != nil {
+		return healthUsage
+	}
+
+	report := func(code int, format string, a ...any) int {
+		if !*quiet {
+			out := os.Stdout
+			if code != healthOK {
+				out = os.Stderr
+			}
+			fmt.Fprintf(out, "healthcheck: "+format+"\n", a...)
+		}
+		return code
+	}
+
+	resp, err := client.send(NotificationRequest{Action: actionPing})
+	if err != nil {
+		return report(healthUnreachable, "%v", err)
+	}
+	data, ok := strings.CutPrefix(resp, "OK ")
+	if !ok {
+		return report(healthUnreachable, "%s", resp)
+	}
+	var p pingResult
+	if err := json.Unmarshal([]byte(data), &p); err != nil {
+		return report(healthUnreachable, "invalid response: %v", err)
+	}
+	if !p.Ready {
+		return report(healthNotReady, "not ready (version %s, up %s)", p.Version, p.Uptime)
+	}
+	return report(healthOK, "ok (version %s, up %s)", p.Version, p.Uptime)
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ahacop/macos-notify-bridge/internal/testutil"
+)
+
+// unavailableNotifier is a backend whose availability check fails.
+type unavailableNotifier struct {
+	*fakeNotifier
+}
+
+func (unavailableNotifier) Available() error { return errors.New("not installed") }
+
+// waitReady waits for s to pass its backend check.
+func waitReady(t *testing.T, s *Server) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for s.readiness() != nil && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := s.readiness(); err != nil {
+		t.Fatalf("expected server to become ready, got %v", err)
+	}
+}
+
+func TestPing(t *testing.T) {
+	fake := &fakeNotifier{}
+	s := NewServer("localhost", 0, false, WithNotifier(fake))
+	runServer(t, s)
+	waitReady(t, s)
+
+	got := roundTrip(t, s, `{"action":"ping"}`)
+	data, ok := strings.CutPrefix(got, "OK ")
+	if !ok {
+		t.Fatalf("expected OK <json>, got %q", got)
+	}
+	var p pingResult
+	if err := json.Unmarshal([]byte(data), &p); err != nil {
+		t.Fatalf("invalid ping JSON: %v", err)
+	}
+	if p.Version != version || !p.Ready || p.Uptime == "" {
+		t.Errorf("expected version, uptime and ready, got %+v", p)
+	}
+	if len(fake.requests()) != 0 {
+		t.Error("expected ping not to deliver a notification")
+	}
+}
+
+func TestHealthEndpoints(t *testing.T) {
+	s := NewServer("localhost", 0, false, WithNotifier(&fakeNotifier{}))
+	get := func(handler http.HandlerFunc, method string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest(method, "/", nil))
+		return rec
+	}
+
+	if rec := get(s.handleHealthz, http.MethodGet); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"version":"`+version+`"`) {
+		t.Errorf("expected 200 with the version, got %d %s", rec.Code, rec.Body)
+	}
+	if rec := get(s.handleReadyz, http.MethodGet); rec.Code != http.StatusServiceUnavailable || !strings.Contains(rec.Body.String(), "starting") {
+		t.Errorf("expected 503 before the server starts, got %d %s", rec.Code, rec.Body)
+	}
+
+	runServer(t, s)
+	waitReady(t, s)
+	if rec := get(s.handleReadyz, http.MethodGet); rec.Code != http.StatusOK {
+		t.Errorf("expected 200 once ready, got %d %s", rec.Code, rec.Body)
+	}
+	if rec := get(s.handleReadyz, http.MethodPost); rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected 405 for POST, got %d", rec.Code)
+	}
+
+	s.Stop()
+	if rec := get(s.handleReadyz, http.MethodGet); rec.Code != http.StatusServiceUnavailable || !strings.Contains(rec.Body.String(), "shutting down") {
+		t.Errorf("expected 503 once stopped, got %d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestReadyzUnavailableBackend(t *testing.T) {
+	s := NewServer("localhost", 0, false, WithNotifier(unavailableNotifier{&fakeNotifier{}}))
+	runServer(t, s)
+
+	rec := httptest.NewRecorder()
+	s.handleReadyz(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if rec.Code != http.StatusServiceUnavailable || !strings.Contains(rec.Body.String(), "backend unavailable") {
+		t.Errorf("expected 503 for an unavailable backend, got %d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestRunHealthcheck(t *testing.T) {
+	ready := NewServer("127.0.0.1", 0, false, WithNotifier(&fakeNotifier{}))
+	runServer(t, ready)
+	waitReady(t, ready)
+	notReady := NewServer("127.0.0.1", 0, false, WithNotifier(unavailableNotifier{&fakeNotifier{}}))
+	runServer(t, notReady)
+
+	// Find a port nothing listens on
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	closed := l.Addr().String()
+	_ = l.Close()
+
+	tests := []struct {
+		name string
+		args []string
+		want int
+	}{
+		{"ready", []string{"--addr", ready.Addr().String()}, healthOK},
+		{"not ready", []string{"--addr", notReady.Addr().String()}, healthNotReady},
+		{"unreachable", []string{"--addr", closed, "--timeout", "1s"}, healthUnreachable},
+		{"bad flag", []string{"--bogus"}, healthUsage},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := runHealthcheck(append(tt.args, "--quiet")); got != tt.want {
+				t.Errorf("expected exit code %d, got %d", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestRunHealthcheckTLS(t *testing.T) {
+	certs, err := testutil.GenerateTestCerts(t.TempDir(), "test-vm")
+	if err != nil {
+		t.Fatalf("failed to generate certificates: %v", err)
+	}
+	reloader, err := newCertReloader(certs.ServerCertFile, certs.ServerKeyFile, certs.CAFile)
+	if err != nil {
+		t.Fatalf("failed to load certificates: %v", err)
+	}
+	s := NewServer("127.0.0.1", 0, false, WithNotifier(&fakeNotifier{}), WithTLS(reloader))
+	runServer(t, s)
+	waitReady(t, s)
+	addr := s.Addr().String()
+
+	tests := []struct {
+		name string
+		args []string
+		want int
+	}{
+		{"mutual TLS", []string{"--tls-ca", certs.CAFile, "--tls-cert", certs.ClientCertFile, "--tls-key", certs.ClientKeyFile}, healthOK},
+		{"plain TCP", nil, healthUnreachable},
+		{"untrusted server", []string{"--tls"}, healthUnreachable},
+		{"no client certificate", []string{"--tls-ca", certs.CAFile}, healthUnreachable},
+		{"certificate without key", []string{"--tls-ca", certs.CAFile, "--tls-cert", certs.ClientCertFile}, healthUnreachable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := append([]string{"--addr", addr, "--timeout", "2s", "--quiet"}, tt.args...)
+			if got := runHealthcheck(args); got != tt.want {
+				t.Errorf("expected exit code %d, got %d", tt.want, got)
+			}
+		})
+	}
+}
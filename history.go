@@ -0,0 +1,310 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultHistorySize is how many notifications the history keeps.
+	defaultHistorySize = 1000
+	// defaultHistoryMaxAge is how long notifications stay in the history.
+	defaultHistoryMaxAge = 7 * 24 * time.Hour
+)
+
+// History statuses: where a received notification ended up.
+const (
+	historyPending   = "pending"
+	historyDelivered = "delivered"
+	historyFailed    = "failed"
+	historyExpired   = "expired"
+	historyDropped   = "dropped"
+	historyDuplicate = "duplicate"
+	historyCancelled = "cancelled"
+	historyCoalesced = "coalesced"
+)
+
+// historyStatuses lists the statuses history queries may filter on.
+var historyStatuses = map[string]bool{
+	historyPending:   true,
+	historyDelivered: true,
+	historyFailed:    true,
+	historyExpired:   true,
+	historyDropped:   true,
+	historyDuplicate: true,
+	historyCancelled: true,
+	historyCoalesced: true,
+}
+
+// origin identifies the client a request came from: its IP address and,
+// if it authenticated, its token name.
+type origin struct {
+	ip    string
+	token string
+}
+
+// historyEntry records a received notification and what became of it.
+// Digest names the digest a coalesced notification was delivered in.
+type historyEntry struct {
+	ID         string              `json:"id"`
+	Sender     string              `json:"sender,omitempty"`
+	Client     string              `json:"client,omitempty"`
+	ReceivedAt time.Time           `json:"received_at"`
+	UpdatedAt  time.Time           `json:"updated_at"`
+	Status     string              `json:"status"`
+	Error      string              `json:"error,omitempty"`
+	Digest     string              `json:"digest,omitempty"`
+	Request    NotificationRequest `json:"request"`
+}
+
+// historyQuery selects history entries. Zero fields match everything;
+// limit keeps only the most recent entries.
+type historyQuery struct {
+	since  time.Time
+	until  time.Time
+	group  string
+	sender string
+	status string
+	limit  int
+}
+
+// matches reports whether e satisfies the query's filters.
+func (q historyQuery) matches(e *historyEntry) bool {
+	switch {
+	case !q.since.IsZero() && e.ReceivedAt.Before(q.since):
+		return false
+	case !q.until.IsZero() && e.ReceivedAt.After(q.until):
+		return false
+	case q.group != "" && e.Request.Group != q.group:
+		return false
+	case q.sender != "" && e.Sender != q.sender && e.Client != q.sender:
+		return false
+	case q.status != "" && e.Status != q.status:
+		return false
+	}
+	return true
+}
+
+// history keeps the most recent notifications in memory, bounded by count
+// and age.
+type history struct {
+	size   int
+	maxAge time.Duration
+	now    func() time.Time
+
+	mu      sync.Mutex
+	entries []*historyEntry // oldest first
+	byID    map[string]*historyEntry
+}
+
+func newHistory(size int, maxAge time.Duration) *history {
+	return &history{
+		size:   size,
+		maxAge: maxAge,
+		now:    time.Now,
+		byID:   make(map[string]*historyEntry),
+	}
+}
+
+// WithHistory keeps up to size notifications, for at most maxAge (zero
+// means no age limit), for history queries. A zero size turns the history
+// off.
+func WithHistory(size int, maxAge time.Duration) Option {
+	return func(s *Server) {
+		if size > 0 {
+			s.history = newHistory(size, maxAge)
+		} else {
+			s.history = nil
+		}
+	}
+}
+
+// record adds a notification received from o under id.
+func (h *history) record(id string, req NotificationRequest, o origin, status string) {
+	req.Token = ""
+	now := h.now()
+	e := &historyEntry{
+		ID:         id,
+		Sender:     o.ip,
+		Client:     o.token,
+		ReceivedAt: now,
+		UpdatedAt:  now,
+		Status:     status,
+		Request:    req,
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.entries = append(h.entries, e)
+	h.byID[id] = e
+	h.pruneLocked(now)
+}
+
+// update sets the status of the notification with the given ID, if it is
+// still in the history.
+func (h *history) update(id, status string, cause error, digest string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	e, ok := h.byID[id]
+	if !ok {
+		return
+	}
+	e.Status = status
+	e.UpdatedAt = h.now()
+	e.Error = ""
+	if cause != nil {
+		e.Error = cause.Error()
+	}
+	if digest != "" {
+		e.Digest = digest
+	}
+}
+
+// pruneLocked drops the oldest entries until the history is within its
+// limits.
+func (h *history) pruneLocked(now time.Time) {
+	drop := 0
+	for drop < len(h.entries) {
+		e := h.entries[drop]
+		if len(h.entries)-drop <= h.size && (h.maxAge <= 0 || now.Sub(e.ReceivedAt) <= h.maxAge) {
+			break
+		}
+		delete(h.byID, e.ID)
+		drop++
+	}
+	if drop > 0 {
+		h.entries = append(h.entries[:0:0], h.entries[drop:]...)
+	}
+}
+
+// query returns copies of the entries matching q, oldest first.
+func (h *history) query(q historyQuery) []historyEntry {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.pruneLocked(h.now())
+
+	matched := []historyEntry{}
+	for _, e := range h.entries {
+		if q.matches(e) {
+			matched = append(matched, *e)
+		}
+	}
+	if q.limit > 0 && len(matched) > q.limit {
+		matched = matched[len(matched)-q.limit:]
+	}
+	return matched
+}
+
+// parseHistoryTime parses a history query bound: an RFC 3339 time or a
+// duration meaning that long before now.
+func parseHistoryTime(s string, now time.Time) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil && d >= 0 {
+		return now.Add(-d), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// historyQueryFor builds the query described by a history request.
+func historyQueryFor(req NotificationRequest, now time.Time) historyQuery {
+	q := historyQuery{group: req.Group, sender: req.Sender, status: req.Status, limit: req.Limit}
+	// validate has already checked the bounds
+	q.since, _ = parseHistoryTime(req.Since, now)
+	q.until, _ = parseHistoryTime(req.Until, now)
+	return q
+}
+
+// errHistoryDisabled is returned for history queries when the server keeps
+// no history.
+var errHistoryDisabled = errors.New("history is disabled")
+
+// queryHistory answers a history request.
+func (s *Server) queryHistory(req NotificationRequest) ([]historyEntry, error) {
+	if s.history == nil {
+		return nil, errHistoryDisabled
+	}
+	return s.history.query(historyQueryFor(req, time.Now())), nil
+}
+
+// recordHistory adds a newly received notification to the history.
+func (s *Server) recordHistory(id string, req NotificationRequest, o origin, status string) {
+	if s.history != nil {
+		s.history.record(id, req, o, status)
+	}
+}
+
+// updateHistory records what became of a notification.
+func (s *Server) updateHistory(id, status string, cause error) {
+	if s.history != nil {
+		s.history.update(id, status, cause, "")
+	}
+}
+
+// recordDigest records that members were delivered in the digest with the
+// given ID, which is added to the history in their place.
+func (s *Server) recordDigest(id string, req NotificationRequest, members []pendingNotification) {
+	if s.history == nil {
+		return
+	}
+	s.history.record(id, req, origin{}, historyPending)
+	for _, m := range members {
+		s.history.update(m.id, historyCoalesced, nil, id)
+	}
+}
+
+// runHistory implements the history subcommand, which queries a running
+// server's history and prints it as JSON.
+func runHistory(args []string) int {
+	fs := flag.NewFlagSet("history", flag.ContinueOnError)
+	since := fs.String("since", "", "Only notifications received after this RFC 3339 time or duration ago, e.g. 1h")
+	until := fs.String("until", "", "Only notifications received before this RFC 3339 time or duration ago")
+	group := fs.String("group", "", "Only notifications in this group")
+	sender := fs.String("sender", "", "Only notifications from this IP address or token name")
+	status := fs.String("status", "", "Only notifications with this status, e.g. failed")
+	limit := fs.Int("limit", 0, "Only the most recent notifications (0 for all)")
+	client := addClientFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	req := NotificationRequest{
+		Action: actionHistory,
+		Since:  *since,
+		Until:  *until,
+		Group:  *group,
+		Sender: *sender,
+		Status: *status,
+		Limit:  *limit,
+	}
+	resp, err := client.send(req)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "history: %v\n", err)
+		return 1
+	}
+	data, ok := strings.CutPrefix(resp, "OK ")
+	if !ok {
+		fmt.Fprintf(os.Stderr, "history: %s\n", resp)
+		return 1
+	}
+
+	var entries []historyEntry
+	if err := json.Unmarshal([]byte(data), &entries); err != nil {
+		fmt.Fprintf(os.Stderr, "history: invalid response: %v\n", err)
+		return 1
+	}
+	out, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "history: %v\n", err)
+		return 1
+	}
+	fmt.Println(string(out))
+	return 0
+}
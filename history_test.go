@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHistoryBounds(t *testing.T) {
+	h := newHistory(3, time.Hour)
+	now := time.Date(2026, 10, 16, 9, 0, 0, 0, time.UTC)
+	h.now = func() time.Time { return now }
+
+	for _, id := range []string{"a", "b", "c", "d"} {
+		h.record(id, NotificationRequest{Title: "T", Message: id}, origin{}, historyPending)
+		now = now.Add(20 * time.Minute)
+	}
+
+	ids := func() string {
+		var got []string
+		for _, e := range h.query(historyQuery{}) {
+			got = append(got, e.ID)
+		}
+		return strings.Join(got, ",")
+	}
+	if got := ids(); got != "b,c,d" {
+		t.Errorf("expected the oldest entry to be evicted, got %q", got)
+	}
+
+	// b was received 60 minutes before d; another 10 minutes ages it out
+	now = now.Add(10 * time.Minute)
+	if got := ids(); got != "c,d" {
+		t.Errorf("expected entries past the max age to be dropped, got %q", got)
+	}
+
+	h.update("a", historyDelivered, nil, "")
+	if _, ok := h.byID["a"]; ok {
+		t.Error("expected update of an evicted entry to be ignored")
+	}
+}
+
+func TestHistoryQuery(t *testing.T) {
+	h := newHistory(100, 0)
+	start := time.Date(2026, 10, 16, 9, 0, 0, 0, time.UTC)
+	now := start
+	h.now = func() time.Time { return now }
+
+	h.record("1", NotificationRequest{Title: "Build", Message: "M", Group: "ci", Token: "s3cret"}, origin{ip: "10.0.0.5", token: "ci"}, historyPending)
+	now = now.Add(time.Minute)
+	h.record("2", NotificationRequest{Title: "Mail", Message: "M"}, origin{ip: "127.0.0.1"}, historyPending)
+	now = now.Add(time.Minute)
+	h.record("3", NotificationRequest{Title: "Build", Message: "M", Group: "ci"}, origin{ip: "10.0.0.6"}, historyPending)
+	h.update("1", historyFailed, errors.New("backend down"), "")
+	h.update("2", historyDelivered, nil, "")
+
+	tests := []struct {
+		name  string
+		query historyQuery
+		want  string
+	}{
+		{"all", historyQuery{}, "1,2,3"},
+		{"group", historyQuery{group: "ci"}, "1,3"},
+		{"sender by ip", historyQuery{sender: "127.0.0.1"}, "2"},
+		{"sender by token", historyQuery{sender: "ci"}, "1"},
+		{"status", historyQuery{status: historyFailed}, "1"},
+		{"since", historyQuery{since: start.Add(time.Minute)}, "2,3"},
+		{"until", historyQuery{until: start.Add(time.Minute)}, "1,2"},
+		{"limit keeps the most recent", historyQuery{limit: 2}, "2,3"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, e := range h.query(tt.query) {
+				got = append(got, e.ID)
+			}
+			if strings.Join(got, ",") != tt.want {
+				t.Errorf("expected %s, got %v", tt.want, got)
+			}
+		})
+	}
+
+	e := h.query(historyQuery{status: historyFailed})[0]
+	if e.Error != "backend down" || e.Request.Token != "" {
+		t.Errorf("expected error recorded and token stripped, got %+v", e)
+	}
+}
+
+func TestHistoryRecordsOutcomes(t *testing.T) {
+	fake := &fakeNotifier{}
+	s := NewServer("localhost", 0, false, WithNotifier(fake), WithDedupWindow(time.Minute), WithSpool(testSpool(t)))
+	t.Cleanup(s.Stop)
+
+	roundTrip(t, s, `{"title":"Deploy","message":"Started","group":"ci"}`)
+	roundTrip(t, s, `{"title":"Deploy","message":"Started","group":"ci"}`)
+	got := roundTrip(t, s, `{"title":"Later","message":"M","delay":"1h"}`)
+	id, _ := strings.CutPrefix(got, "SCHEDULED ")
+	roundTrip(t, s, `{"action":"cancel","id":"`+id+`"}`)
+
+	res, err := s.perform(NotificationRequest{Action: actionHistory}, origin{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var statuses []string
+	for _, e := range res.data.([]historyEntry) {
+		statuses = append(statuses, e.Status)
+		if e.Sender != "pipe" {
+			t.Errorf("expected sender to be recorded, got %q", e.Sender)
+		}
+	}
+	if got := strings.Join(statuses, ","); got != "delivered,duplicate,cancelled" {
+		t.Errorf("expected delivered,duplicate,cancelled, got %s", got)
+	}
+
+	// Over the protocol the history is returned as JSON
+	got = roundTrip(t, s, `{"action":"history","status":"cancelled","since":"1h"}`)
+	data, ok := strings.CutPrefix(got, "OK ")
+	if !ok {
+		t.Fatalf("expected OK <json>, got %q", got)
+	}
+	var entries []historyEntry
+	if err := json.Unmarshal([]byte(data), &entries); err != nil {
+		t.Fatalf("invalid history JSON: %v", err)
+	}
+	if len(entries) != 1 || entries[0].ID != id || entries[0].Request.Title != "Later" {
+		t.Errorf("expected the cancelled notification, got %+v", entries)
+	}
+
+	if got := roundTrip(t, s, `{"action":"history","status":"lost"}`); got != `ERROR: invalid status: unknown status "lost"` {
+		t.Errorf("expected invalid status error, got %q", got)
+	}
+}
+
+func TestHistoryRecordsCoalescedMembers(t *testing.T) {
+	c, err := newCoalescer(20*time.Millisecond, 50, defaultCoalesceTemplate)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	fake := &fakeNotifier{}
+	s := NewServer("localhost", 0, false, WithNotifier(fake), WithCoalescer(c), WithAsync())
+	t.Cleanup(s.Stop)
+
+	roundTrip(t, s, `{"title":"Tests","message":"passed","group":"ci"}`)
+	roundTrip(t, s, `{"title":"Tests","message":"failed","group":"ci"}`)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for len(fake.requests()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	s.Stop()
+
+	entries := s.history.query(historyQuery{})
+	if len(entries) != 3 {
+		t.Fatalf("expected both members and the digest, got %+v", entries)
+	}
+	digest := entries[2]
+	if digest.Status != historyDelivered || digest.Sender != "" {
+		t.Errorf("expected the delivered digest, got %+v", digest)
+	}
+	for _, e := range entries[:2] {
+		if e.Status != historyCoalesced || e.Digest != digest.ID {
+			t.Errorf("expected member coalesced into %s, got %+v", digest.ID, e)
+		}
+	}
+	if entries[1].Request.Message != "failed" {
+		t.Errorf("expected members to keep their own messages, got %+v", entries[1])
+	}
+}
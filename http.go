@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxHTTPBodySize caps the size of an HTTP notification request body.
+const maxHTTPBodySize = 64 << 10
+
+// NotificationResponse is the JSON body returned by the HTTP API.
+type NotificationResponse struct {
+	Status string `json:"status"`
+	Code   string `json:"code,omitempty"`
+	ID     string `json:"id,omitempty"`
+	Error  string `json:"error,omitempty"`
+	Result any    `json:"result,omitempty"`
+}
+
+// WithHTTPPort enables the HTTP API on the given port of the server's host.
+// A port of 0 leaves the HTTP API disabled.
+func WithHTTPPort(port int) Option {
+	return func(s *Server) {
+		s.httpPort = port
+	}
+}
+
+// httpHandler returns the router for the HTTP API.
+func (s *Server) httpHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/notify", s.handleHTTPNotify)
+	mux.HandleFunc("/healthz", s.handleHealthz)
+	mux.HandleFunc("/readyz", s.handleReadyz)
+	return mux
+}
+
+func (s *Server) startHTTP() error {
+	listener, err := listenTCP(s.host, s.httpPort)
+	if err != nil {
+		return err
+	}
+	s.serveHTTP(listener)
+	return nil
+}
+
+// serveHTTP serves the HTTP API on listener in place of the current HTTP
+// server, which it returns. A nil listener stops serving the HTTP API.
+func (s *Server) serveHTTP(listener net.Listener) *http.Server {
+	var httpServer *http.Server
+	if listener != nil {
+		s.log.Info("HTTP API listening", "addr", listener.Addr().String(), "tls", s.currentTLS() != nil)
+		httpServer = &http.Server{
+			Handler:           s.httpHandler(),
+			ReadHeaderTimeout: 10 * time.Second,
+			ReadTimeout:       30 * time.Second,
+		}
+		listener = s.wrapTLS(listener)
+	}
+	return s.replaceHTTPServer(&s.httpServer, httpServer, listener)
+}
+
+// replaceHTTPServer stores httpServer in *slot and starts it serving on
+// listener, returning the server it replaces. Once the server is stopping,
+// listener is closed instead.
+func (s *Server) replaceHTTPServer(slot **http.Server, httpServer *http.Server, listener net.Listener) *http.Server {
+	s.mu.Lock()
+	select {
+	case <-s.shutdown:
+		s.mu.Unlock()
+		if listener != nil {
+			_ = listener.Close()
+		}
+		return nil
+	default:
+	}
+	old := *slot
+	*slot = httpServer
+	s.mu.Unlock()
+
+	if httpServer != nil {
+		go func() {
+			if err := httpServer.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				s.log.Error("HTTP server error", "error", err)
+			}
+		}()
+	}
+	return old
+}
+
+// stopHTTP shuts down the HTTP API and metrics servers.
+func (s *Server) stopHTTP() {
+	s.mu.Lock()
+	servers := []*http.Server{s.httpServer, s.metricsServer}
+	s.mu.Unlock()
+	s.shutdownHTTP(servers...)
+}
+
+// shutdownHTTP gracefully shuts down each non-nil server, letting requests
+// in progress finish.
+func (s *Server) shutdownHTTP(servers ...*http.Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, httpServer := range servers {
+		if httpServer == nil {
+			continue
+		}
+		if err := httpServer.Shutdown(ctx); err != nil {
+			s.log.Debug("Error shutting down HTTP server", "error", err)
+		}
+	}
+}
+
+func (s *Server) handleHTTPNotify(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		s.writeJSON(w, http.StatusMethodNotAllowed, NotificationResponse{Status: "error", Error: "method not allowed"})
+		return
+	}
+
+	remote := r.RemoteAddr
+	if r.TLS != nil {
+		if id := certIdentity(r.TLS.PeerCertificates); id != "" {
+			remote = fmt.Sprintf("%s [%s]", remote, id)
+		}
+	}
+	start := time.Now()
+	log := s.log.With("remote_addr", remote)
+	log.Debug("New HTTP request")
+
+	var req NotificationRequest
+	body := http.MaxBytesReader(w, r.Body, maxHTTPBodySize)
+	if err := json.NewDecoder(body).Decode(&req); err != nil {
+		log.Debug("Error parsing JSON", "error", err)
+		s.finishRequest(log, start, result{}, outcomeInvalidJSON, err)
+		s.writeJSON(w, http.StatusBadRequest, errorResponse(outcomeInvalidJSON, err))
+		return
+	}
+
+	log.Debug("Received request", "request", req)
+
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && req.Token == "" {
+		req.Token = token
+	}
+	name, err := s.authenticate(req.Token, remote)
+	if err != nil {
+		s.finishRequest(log, start, result{}, outcomeUnauthorized, err)
+		s.writeJSON(w, http.StatusUnauthorized, errorResponse(outcomeUnauthorized, err))
+		return
+	}
+	if err := s.checkRate(name, hostOnly(r.RemoteAddr)); err != nil {
+		s.finishRequest(log, start, result{}, outcomeRateLimited, err)
+		var limited *rateLimitError
+		if errors.As(err, &limited) {
+			w.Header().Set("Retry-After", strconv.Itoa(limited.seconds()))
+		}
+		s.writeJSON(w, http.StatusTooManyRequests, errorResponse(outcomeRateLimited, err))
+		return
+	}
+
+	res, err := s.perform(req, origin{ip: hostOnly(r.RemoteAddr), token: name})
+	s.finishRequest(log, start, res, requestOutcome(res, err), err)
+	if err != nil {
+		status := http.StatusBadGateway
+		switch {
+		case isValidationError(err):
+			status = http.StatusBadRequest
+		case errors.Is(err, errUnsupported), errors.Is(err, errHistoryDisabled), errors.Is(err, errScheduleNoSpool):
+			status = http.StatusNotImplemented
+		case errors.Is(err, errNotFound):
+			status = http.StatusNotFound
+		case errors.Is(err, errQueueFull), errors.Is(err, errQueueClosed), errors.Is(err, errSpoolFull):
+			status = http.StatusServiceUnavailable
+		}
+		s.writeJSON(w, status, errorResponse(requestOutcome(res, err), err))
+		return
+	}
+
+	status := http.StatusOK
+	if res.status == statusQueued || res.status == statusScheduled {
+		status = http.StatusAccepted
+	}
+	s.writeJSON(w, status, NotificationResponse{Status: res.status, ID: res.id, Result: res.data})
+}
+
+func (s *Server) writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		s.log.Debug("Error writing HTTP response", "error", err)
+	}
+}
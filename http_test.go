@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHTTPNotify(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		body       string
+		backendErr error
+		wantStatus int
+		wantResp   NotificationResponse
+		delivered  int
+	}{
+		{
+			name:       "valid notification",
+			method:     http.MethodPost,
+			body:       `{"title":"Test","message":"Hello","sound":"Hero"}`,
+			wantStatus: http.StatusOK,
+			wantResp:   NotificationResponse{Status: "ok"},
+			delivered:  1,
+		},
+		{
+			name:       "invalid json",
+			method:     http.MethodPost,
+			body:       `invalid json`,
+			wantStatus: http.StatusBadRequest,
+			wantResp:   NotificationResponse{Status: "error", Code: "invalid_json", Error: "invalid JSON"},
+		},
+		{
+			name:       "missing message",
+			method:     http.MethodPost,
+			body:       `{"title":"Test"}`,
+			wantStatus: http.StatusBadRequest,
+			wantResp:   NotificationResponse{Status: "error", Code: "missing_field", Error: "missing title or message"},
+		},
+		{
+			name:       "backend failure",
+			method:     http.MethodPost,
+			body:       `{"title":"Test","message":"Hello"}`,
+			backendErr: errors.New("backend down"),
+			wantStatus: http.StatusBadGateway,
+			wantResp:   NotificationResponse{Status: "error", Code: "backend_failed", Error: "backend down"},
+		},
+		{
+			name:       "wrong method",
+			method:     http.MethodGet,
+			wantStatus: http.StatusMethodNotAllowed,
+			wantResp:   NotificationResponse{Status: "error", Error: "method not allowed"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeNotifier{err: tt.backendErr}
+			s := NewServer("localhost", 0, false, WithNotifier(fake), WithRetry(1, 0, 0))
+
+			req := httptest.NewRequest(tt.method, "/v1/notify", strings.NewReader(tt.body))
+			rec := httptest.NewRecorder()
+			s.httpHandler().ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("expected status %d, got %d", tt.wantStatus, rec.Code)
+			}
+			if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+				t.Errorf("expected JSON content type, got %q", ct)
+			}
+
+			var resp NotificationResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("failed to decode response %q: %v", rec.Body.String(), err)
+			}
+			if tt.delivered > 0 && resp.ID == "" {
+				t.Error("expected delivered notification to have an ID")
+			}
+			resp.ID = ""
+			if resp != tt.wantResp {
+				t.Errorf("expected response %+v, got %+v", tt.wantResp, resp)
+			}
+			if got := len(fake.requests()); got != tt.delivered {
+				t.Errorf("expected %d delivered notifications, got %d", tt.delivered, got)
+			}
+		})
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 )
 
 // CreateMockTerminalNotifier creates a mock terminal-notifier executable
@@ -13,14 +15,65 @@ func CreateMockTerminalNotifier(dir string) (string, error) {
 	mockPath := filepath.Join(dir, "terminal-notifier")
 	logPath := filepath.Join(dir, "notifications.log")
 
-	mockScript := fmt.Sprintf(`#!/bin/sh
-# Mock terminal-notifier for testing
+	if err := os.WriteFile(mockPath, []byte(mockScript("", logPath)), 0755); err != nil {
+		return "", fmt.Errorf("failed to create mock terminal-notifier: %w", err)
+	}
+
+	return mockPath, nil
+}
+
+// CreateFlakyMockTerminalNotifier creates a mock that fails the first
+// failures times it is run, then logs notifications like
+// CreateMockTerminalNotifier
+func CreateFlakyMockTerminalNotifier(dir string, failures int) (string, error) {
+	mockPath := filepath.Join(dir, "terminal-notifier")
+	logPath := filepath.Join(dir, "notifications.log")
+	countPath := filepath.Join(dir, "attempts")
+
+	preamble := fmt.Sprintf(`# Fail the first %d runs
+ATTEMPTS=$(cat %s 2>/dev/null || echo 0)
+ATTEMPTS=$((ATTEMPTS + 1))
+echo "$ATTEMPTS" > %s
+if [ "$ATTEMPTS" -le %d ]; then
+  echo "Mock error: terminal-notifier failed (attempt $ATTEMPTS)" >&2
+  exit 1
+fi
+`, failures, countPath, countPath, failures)
+
+	if err := os.WriteFile(mockPath, []byte(mockScript(preamble, logPath)), 0755); err != nil {
+		return "", fmt.Errorf("failed to create flaky mock terminal-notifier: %w", err)
+	}
+
+	return mockPath, nil
+}
+
+// ReadAttemptCount returns how many times a mock created by
+// CreateFlakyMockTerminalNotifier has been run
+func ReadAttemptCount(dir string) (int, error) {
+	data, err := os.ReadFile(filepath.Join(dir, "attempts"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(data)))
+}
 
+// mockScript returns a shell script that runs preamble and then logs the
+// notification described by its arguments to logPath
+func mockScript(preamble, logPath string) string {
+	return fmt.Sprintf(`#!/bin/sh
+# Mock terminal-notifier for testing
+%s
 # Parse arguments
 TITLE=""
 MESSAGE=""
 SENDER=""
 SOUND=""
+SUBTITLE=""
+GROUP=""
+OPEN=""
 
 while [ "$#" -gt 0 ]; do
   case "$1" in
@@ -40,6 +93,18 @@ while [ "$#" -gt 0 ]; do
       SOUND="$2"
       shift 2
       ;;
+    -subtitle)
+      SUBTITLE="$2"
+      shift 2
+      ;;
+    -group)
+      GROUP="$2"
+      shift 2
+      ;;
+    -open)
+      OPEN="$2"
+      shift 2
+      ;;
     *)
       shift
       ;;
@@ -47,17 +112,11 @@ while [ "$#" -gt 0 ]; do
 done
 
 # Log the notification
-echo "$(date '+%%Y-%%m-%%d %%H:%%M:%%S') - Title: $TITLE, Message: $MESSAGE, Sender: $SENDER, Sound: $SOUND" >> %s
+echo "$(date '+%%Y-%%m-%%d %%H:%%M:%%S') - Title: $TITLE, Message: $MESSAGE, Sender: $SENDER, Sound: $SOUND, Subtitle: $SUBTITLE, Group: $GROUP, Open: $OPEN" >> %s
 
 # Exit successfully
 exit 0
-`, logPath)
-
-	if err := os.WriteFile(mockPath, []byte(mockScript), 0755); err != nil {
-		return "", fmt.Errorf("failed to create mock terminal-notifier: %w", err)
-	}
-
-	return mockPath, nil
+`, preamble, logPath)
 }
 
 // CreateFailingMockTerminalNotifier creates a mock that always fails
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+)
+
+// Log formats accepted by --log-format.
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+// parseLogLevel parses a level name as accepted by --log-level.
+func parseLogLevel(name string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(name)); err != nil {
+		return 0, fmt.Errorf("invalid log level %q (use debug, info, warn or error)", name)
+	}
+	return level, nil
+}
+
+// newLogger returns a logger writing records at level or above to w, as
+// logfmt-style text or as JSON lines.
+func newLogger(w io.Writer, format string, level slog.Level) (*slog.Logger, error) {
+	opts := &slog.HandlerOptions{Level: level}
+	switch strings.ToLower(format) {
+	case logFormatText:
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case logFormatJSON:
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	default:
+		return nil, fmt.Errorf("invalid log format %q (use text or json)", format)
+	}
+}
+
+// WithLogger sets the logger for the server's diagnostics. Without it the
+// server logs to slog.Default, or at debug level to stderr if it was
+// created verbose.
+func WithLogger(l *slog.Logger) Option {
+	return func(s *Server) {
+		s.log = l
+	}
+}
+
+// LogValue logs the request's fields that are set, with its token masked.
+func (r NotificationRequest) LogValue() slog.Value {
+	var attrs []slog.Attr
+	for _, f := range []struct{ key, value string }{
+		{"action", r.Action},
+		{"id", r.ID},
+		{"title", r.Title},
+		{"message", r.Message},
+		{"subtitle", r.Subtitle},
+		{"sound", r.Sound},
+		{"group", r.Group},
+		{"open", r.Open},
+		{"activate", r.Activate},
+		{"app_icon", r.AppIcon},
+		{"content_image", r.ContentImage},
+		{"dedup_key", r.DedupKey},
+		{"deliver_at", r.DeliverAt},
+		{"delay", r.Delay},
+		{"ttl", r.TTL},
+		{"expires_at", r.ExpiresAt},
+	} {
+		if f.value != "" {
+			attrs = append(attrs, slog.String(f.key, f.value))
+		}
+	}
+	if r.IgnoreDnD {
+		attrs = append(attrs, slog.Bool("ignore_dnd", true))
+	}
+	if r.Urgent {
+		attrs = append(attrs, slog.Bool("urgent", true))
+	}
+	if r.Token != "" {
+		attrs = append(attrs, slog.String("token", "REDACTED"))
+	}
+	return slog.GroupValue(attrs...)
+}
+
+// finishRequest counts a handled request and logs its outcome, along with
+// the ID of the notification it concerned and how long it took. Requests
+// that failed on the server's side are logged as warnings.
+func (s *Server) finishRequest(log *slog.Logger, start time.Time, res result, outcome string, err error) {
+	s.metrics.countRequest(outcome)
+
+	level := slog.LevelInfo
+	if outcome == outcomeBackendFailure || outcome == outcomeUnavailable {
+		level = slog.LevelWarn
+	}
+	attrs := []slog.Attr{
+		slog.String("outcome", outcome),
+		slog.Float64("duration_ms", float64(time.Since(start).Microseconds())/1000),
+	}
+	if res.id != "" {
+		attrs = append(attrs, slog.String("request_id", res.id))
+	}
+	if err != nil {
+		attrs = append(attrs, slog.String("error", err.Error()))
+	}
+	log.LogAttrs(context.Background(), level, "Request handled", attrs...)
+}
+
+// fatal logs err and exits.
+func fatal(err error) {
+	slog.Error(err.Error())
+	os.Exit(1)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestParseLogLevel(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    slog.Level
+		wantErr bool
+	}{
+		{"debug", slog.LevelDebug, false},
+		{"info", slog.LevelInfo, false},
+		{"WARN", slog.LevelWarn, false},
+		{"error", slog.LevelError, false},
+		{"verbose", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseLogLevel(tt.name)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseLogLevel(%q): unexpected error %v", tt.name, err)
+		}
+		if err == nil && got != tt.want {
+			t.Errorf("parseLogLevel(%q): expected %v, got %v", tt.name, tt.want, got)
+		}
+	}
+
+	if _, err := newLogger(&syncBuffer{}, "xml", slog.LevelInfo); err == nil {
+		t.Error("expected unknown log format to be rejected")
+	}
+}
+
+func TestRequestLogValueRedactsToken(t *testing.T) {
+	logs := &syncBuffer{}
+	logger, err := newLogger(logs, logFormatText, slog.LevelInfo)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	logger.Info("Received request", "request", NotificationRequest{Title: "T", Message: "M", Token: "secret-one"})
+
+	got := logs.String()
+	if strings.Contains(got, "secret-one") || !strings.Contains(got, "request.token=REDACTED") {
+		t.Errorf("expected token to be redacted, got %s", got)
+	}
+	if !strings.Contains(got, "request.title=T") {
+		t.Errorf("expected request fields to be logged, got %s", got)
+	}
+}
+
+func TestRequestLogFields(t *testing.T) {
+	logs := &syncBuffer{}
+	logger, err := newLogger(logs, logFormatJSON, slog.LevelInfo)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	s := NewServer("localhost", 0, false, WithNotifier(&fakeNotifier{}), WithLogger(logger))
+	t.Cleanup(s.Stop)
+
+	roundTrip(t, s, `{"title":"T","message":"M"}`)
+	roundTrip(t, s, `not json`)
+
+	var records []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+		var record map[string]any
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("expected JSON log lines, got %q", line)
+		}
+		if record["msg"] == "Request handled" {
+			records = append(records, record)
+		}
+	}
+	if len(records) != 2 {
+		t.Fatalf("expected 2 request records, got %d:\n%s", len(records), logs.String())
+	}
+
+	for _, key := range []string{"conn_id", "remote_addr", "request_id", "outcome", "duration_ms"} {
+		if _, ok := records[0][key]; !ok {
+			t.Errorf("expected %s in %v", key, records[0])
+		}
+	}
+	if records[0]["outcome"] != "ok" || records[1]["outcome"] != "invalid_json" {
+		t.Errorf("expected outcomes ok and invalid_json, got %v and %v", records[0]["outcome"], records[1]["outcome"])
+	}
+	if records[0]["conn_id"] == records[1]["conn_id"] {
+		t.Errorf("expected each connection to get its own conn_id, got %v twice", records[0]["conn_id"])
+	}
+}
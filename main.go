@@ -2,105 +2,336 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
 	"net"
+	"net/http"
 	"os"
-	"os/exec"
 	"os/signal"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 )
 
 const version = "0.1.0"
 
-// NotificationRequest represents a notification request from a client.
-type NotificationRequest struct {
-	Title   string `json:"title"`
-	Message string `json:"message"`
-	Sound   string `json:"sound,omitempty"`
-}
-
 // Server represents the notification bridge server.
 type Server struct {
-	host     string
-	port     int
-	verbose  bool
-	listener net.Listener
-	wg       sync.WaitGroup
-	shutdown chan struct{}
+	host          string
+	port          int
+	httpPort      int
+	idleTimeout   time.Duration
+	verbose       bool
+	log           *slog.Logger
+	connSeq       atomic.Uint64
+	started       time.Time
+	backendReady  atomic.Bool
+	notifier      Notifier
+	tokens        *tokenSet
+	tls           *certReloader
+	noTCP         bool
+	socketPath    string
+	socketMode    os.FileMode
+	socketOwner   string
+	workers       int
+	queueDepth    int
+	async         bool
+	queue         *deliveryQueue
+	spool         *spool
+	retry         retryPolicy
+	deadLetters   *deadLetterFile
+	limiter       *rateLimiter
+	dedup         *deduplicator
+	coalescer     *coalescer
+	quiet         *quietHours
+	scheduler     *scheduler
+	history       *history
+	metrics       *metrics
+	metricsAddr   string
+	listener      net.Listener
+	unixListener  net.Listener
+	httpServer    *http.Server
+	metricsServer *http.Server
+	mu            sync.Mutex
+	cfgMu         sync.RWMutex // guards settings swapped by Reconfigure
+	reconfigMu    sync.Mutex
+	wg            sync.WaitGroup
+	ready         chan struct{}
+	shutdown      chan struct{}
+	stopOnce      sync.Once
+}
+
+// defaultIdleTimeout is how long a TCP connection may sit idle between
+// requests before the server closes it.
+const defaultIdleTimeout = 30 * time.Second
+
+// Option configures optional Server behaviour.
+type Option func(*Server)
+
+// WithNotifier sets the backend used to deliver notifications.
+func WithNotifier(n Notifier) Option {
+	return func(s *Server) {
+		s.notifier = n
+	}
+}
+
+// WithIdleTimeout sets how long a TCP connection may wait for its next request.
+func WithIdleTimeout(d time.Duration) Option {
+	return func(s *Server) {
+		s.idleTimeout = d
+	}
+}
+
+// NewServer creates a new notification bridge server instance. Without a
+// WithNotifier option, notifications are delivered via terminal-notifier.
+func NewServer(host string, port int, verbose bool, opts ...Option) *Server {
+	s := &Server{
+		host:        host,
+		port:        port,
+		idleTimeout: defaultIdleTimeout,
+		socketMode:  defaultSocketMode,
+		workers:     defaultWorkers,
+		queueDepth:  defaultQueueDepth,
+		retry:       retryPolicy{attempts: defaultRetryAttempts, baseDelay: defaultRetryDelay, jitter: defaultRetryJitter},
+		verbose:     verbose,
+		started:     time.Now(),
+		log:         slog.Default(),
+		ready:       make(chan struct{}),
+		shutdown:    make(chan struct{}),
+		scheduler:   newScheduler(),
+		history:     newHistory(defaultHistorySize, defaultHistoryMaxAge),
+		metrics:     newMetrics(),
+	}
+	if verbose {
+		s.log = slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.notifier == nil {
+		s.notifier = &terminalNotifier{verbose: verbose}
+	}
+	s.queue = newDeliveryQueue(s.workers, s.queueDepth, s.deliver)
+	s.scheduler.fire = s.fireScheduled
+	if s.coalescer != nil {
+		s.coalescer.flush = s.flushDigest
+	}
+	if s.quiet != nil {
+		s.quiet.flush = s.releaseHeld
+	}
+	return s
+}
+
+// Run opens the server's listeners and serves requests until ctx is
+// cancelled or Stop is called, then shuts down gracefully. Ready is closed
+// once the listeners are open.
+func (s *Server) Run(ctx context.Context) error {
+	if err := s.listen(); err != nil {
+		return err
+	}
+	close(s.ready)
+	go s.probeBackend()
+
+	select {
+	case <-ctx.Done():
+		s.log.Info("Shutting down server")
+	case <-s.shutdown:
+	}
+	s.Stop()
+	return nil
+}
+
+// Ready returns a channel that is closed once Run has opened the server's
+// listeners and Addr can be used.
+func (s *Server) Ready() <-chan struct{} {
+	return s.ready
 }
 
-// NewServer creates a new notification bridge server instance.
-func NewServer(host string, port int, verbose bool) *Server {
-	return &Server{
-		host:     host,
-		port:     port,
-		verbose:  verbose,
-		shutdown: make(chan struct{}),
+// Addr returns the address of the TCP listener, which reflects the port
+// actually chosen when the server was created with port 0. It returns nil
+// before the server is ready or when TCP is disabled.
+func (s *Server) Addr() net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.listener == nil {
+		return nil
 	}
+	return s.listener.Addr()
 }
 
-// Start starts the server and begins listening for connections.
-func (s *Server) Start() error {
-	addr := fmt.Sprintf("%s:%d", s.host, s.port)
+// listen opens the server's listeners and starts accepting connections.
+func (s *Server) listen() error {
+	select {
+	case <-s.shutdown:
+		return errors.New("server already stopped")
+	default:
+	}
+
+	if !s.noTCP {
+		listener, err := listenTCP(s.host, s.port)
+		if err != nil {
+			return err
+		}
+		s.serveTCP(listener)
+	}
+
+	if s.socketPath != "" {
+		listener, err := s.listenUnix()
+		if err != nil {
+			s.Stop()
+			return err
+		}
+		s.serveUnix(listener)
+	}
+
+	if s.httpPort > 0 {
+		if err := s.startHTTP(); err != nil {
+			s.Stop()
+			return err
+		}
+	}
+
+	if s.metricsAddr != "" {
+		if err := s.startMetrics(); err != nil {
+			s.Stop()
+			return err
+		}
+	}
+
+	if s.spool != nil {
+		go s.replaySpool(s.spool.takeRecovered())
+	}
+	return nil
+}
+
+// listenTCP opens a TCP listener on host and port.
+func listenTCP(host string, port int) (net.Listener, error) {
+	addr := net.JoinHostPort(host, strconv.Itoa(port))
 	listener, err := net.Listen("tcp", addr)
 	if err != nil {
-		return fmt.Errorf("failed to listen on %s: %w", addr, err)
+		return nil, fmt.Errorf("failed to listen on %s: %w", addr, err)
 	}
-	s.listener = listener
+	return listener, nil
+}
 
-	log.Printf("Server listening on %s", addr)
+// serveTCP accepts connections on listener, over TLS when it is configured,
+// in place of the current TCP listener, which it returns. A nil listener
+// stops serving TCP.
+func (s *Server) serveTCP(listener net.Listener) net.Listener {
+	if listener != nil {
+		s.log.Info("Server listening", "addr", listener.Addr().String(), "tls", s.currentTLS() != nil)
+		listener = s.wrapTLS(listener)
+	}
+	return s.replaceListener(&s.listener, listener)
+}
 
-	go s.acceptConnections()
+// serveUnix accepts connections on listener in place of the current Unix
+// socket listener, which it returns. A nil listener stops serving the
+// socket.
+func (s *Server) serveUnix(listener net.Listener) net.Listener {
+	return s.replaceListener(&s.unixListener, listener)
+}
 
-	// Wait for shutdown signal
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-	<-sigChan
+// replaceListener stores listener in *slot and starts accepting connections
+// on it, returning the listener it replaces. Once the server is stopping,
+// listener is closed instead.
+func (s *Server) replaceListener(slot *net.Listener, listener net.Listener) net.Listener {
+	s.mu.Lock()
+	select {
+	case <-s.shutdown:
+		s.mu.Unlock()
+		if listener != nil {
+			_ = listener.Close()
+		}
+		return nil
+	default:
+	}
+	old := *slot
+	*slot = listener
+	// Counted so that Stop waits for the loop, and connections it accepts
+	// are added to the wait group before Stop waits on it
+	if listener != nil {
+		s.wg.Add(1)
+	}
+	s.mu.Unlock()
 
-	log.Println("Shutting down server...")
-	s.Stop()
-	return nil
+	if listener != nil {
+		go s.acceptConnections(listener)
+	}
+	return old
 }
 
-// Stop gracefully shuts down the server.
+// Stop gracefully shuts down the server, waiting for in-flight connections
+// to finish. It is safe to call more than once.
 func (s *Server) Stop() {
-	close(s.shutdown)
-	if s.listener != nil {
-		if err := s.listener.Close(); err != nil {
-			if s.verbose {
-				log.Printf("Error closing listener: %v", err)
+	s.stopOnce.Do(func() {
+		close(s.shutdown)
+
+		s.mu.Lock()
+		listeners := []net.Listener{s.listener, s.unixListener}
+		s.mu.Unlock()
+
+		for _, listener := range listeners {
+			if listener == nil {
+				continue
+			}
+			if err := listener.Close(); err != nil {
+				s.log.Debug("Error closing listener", "error", err)
 			}
 		}
-	}
-	s.wg.Wait()
-	log.Println("Server stopped")
+		// Send held back digests so clients waiting on them can finish
+		if s.coalescer != nil {
+			s.coalescer.close()
+		}
+		if n := s.scheduler.close(); n > 0 {
+			s.log.Info("Keeping scheduled notifications in the spool", "count", n)
+		}
+		if s.quiet != nil {
+			if n := s.quiet.close(); n > 0 && s.spool != nil {
+				s.log.Info("Keeping notifications held for quiet hours in the spool", "count", n)
+			} else if n > 0 {
+				s.log.Warn("Discarding notifications held for quiet hours", "count", n)
+			}
+		}
+		s.stopHTTP()
+		s.wg.Wait()
+		// Deliver anything still queued before exiting
+		s.queue.close()
+		s.log.Info("Server stopped")
+	})
 }
 
-func (s *Server) acceptConnections() {
+func (s *Server) acceptConnections(listener net.Listener) {
+	defer s.wg.Done()
 	for {
 		select {
 		case <-s.shutdown:
 			return
 		default:
-			conn, err := s.listener.Accept()
+			conn, err := listener.Accept()
 			if err != nil {
 				select {
 				case <-s.shutdown:
 					return
 				default:
-					if s.verbose {
-						log.Printf("Error accepting connection: %v", err)
+					// Closed by Reconfigure after re-binding elsewhere
+					if errors.Is(err, net.ErrClosed) {
+						return
 					}
+					s.log.Debug("Error accepting connection", "error", err)
 					continue
 				}
 			}
 
+			s.metrics.connections.Add(1)
 			s.wg.Add(1)
 			go s.handleConnection(conn)
 		}
@@ -109,165 +340,294 @@ func (s *Server) acceptConnections() {
 
 func (s *Server) handleConnection(conn net.Conn) {
 	defer s.wg.Done()
+	s.metrics.active.Add(1)
+	defer s.metrics.active.Add(-1)
+	log := s.log.With("conn_id", s.connSeq.Add(1))
 	defer func() {
-		if err := conn.Close(); err != nil && s.verbose {
-			log.Printf("Error closing connection: %v", err)
+		if err := conn.Close(); err != nil {
+			log.Debug("Error closing connection", "error", err)
 		}
 	}()
 
-	if s.verbose {
-		log.Printf("New connection from %s", conn.RemoteAddr())
+	remote, err := s.peerName(conn)
+	if err != nil {
+		log.Debug("Error accepting connection", "error", err)
+		return
 	}
-
-	// Set read timeout
-	if err := conn.SetReadDeadline(time.Now().Add(30 * time.Second)); err != nil {
-		if s.verbose {
-			log.Printf("Error setting read deadline: %v", err)
+	log = log.With("remote_addr", remote)
+	log.Debug("New connection")
+	ip := clientIP(conn)
+
+	// Unblock an idle read when the server shuts down; requests already
+	// being processed are allowed to finish.
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-s.shutdown:
+			if err := conn.SetReadDeadline(time.Now()); err != nil {
+				log.Debug("Error interrupting connection", "error", err)
+			}
+		case <-done:
 		}
-		// Continue anyway, connection might still work
-	}
+	}()
 
 	reader := bufio.NewReader(conn)
-	data, err := reader.ReadString('\n')
-	if err != nil {
-		if s.verbose {
-			log.Printf("Error reading from connection: %v", err)
+	version := protocolV1
+	for served := 0; ; served++ {
+		// Each request gets a fresh idle timeout
+		if err := conn.SetReadDeadline(time.Now().Add(s.idleTimeout)); err != nil {
+			log.Debug("Error setting read deadline", "error", err)
+			// Continue anyway, connection might still work
+		}
+		select {
+		case <-s.shutdown:
+			return
+		default:
 		}
-		if _, err := conn.Write([]byte("ERROR: Failed to read request\n")); err != nil {
-			if s.verbose {
-				log.Printf("Error writing error response: %v", err)
+
+		data, err := reader.ReadString('\n')
+		if err != nil {
+			if served > 0 && data == "" && (errors.Is(err, io.EOF) || errors.Is(err, os.ErrDeadlineExceeded)) {
+				// Client closed or went idle after its last request
+				return
+			}
+			select {
+			case <-s.shutdown:
+				return
+			default:
+			}
+			log.Debug("Error reading from connection", "error", err)
+			if _, err := conn.Write([]byte(readFailedResponse(version))); err != nil {
+				log.Debug("Error writing error response", "error", err)
 			}
+			return
 		}
-		return
-	}
 
-	data = strings.TrimSpace(data)
-	if s.verbose {
-		log.Printf("Received: %s", data)
+		if _, err := conn.Write([]byte(s.handleLine(log, data, remote, ip, &version))); err != nil {
+			log.Debug("Error writing response", "error", err)
+			return
+		}
 	}
+}
+
+// handleLine processes a single newline-delimited request from remote, whose
+// IP address is ip, and returns the response line to send back in the
+// connection's protocol version, which the request may change. The outcome
+// is logged to log.
+func (s *Server) handleLine(log *slog.Logger, data, remote, ip string, version *int) string {
+	start := time.Now()
+	res, outcome, err := s.handleRequest(log, strings.TrimSpace(data), remote, ip, version)
+	s.finishRequest(log, start, res, outcome, err)
+	return formatResponse(*version, res, outcome, err)
+}
 
+// handleRequest parses, authenticates and performs a request, returning its
+// result and outcome. A request naming a protocol version switches the
+// connection to it.
+func (s *Server) handleRequest(log *slog.Logger, data, remote, ip string, version *int) (result, string, error) {
 	var req NotificationRequest
 	if err := json.Unmarshal([]byte(data), &req); err != nil {
-		if s.verbose {
-			log.Printf("Error parsing JSON: %v", err)
-		}
-		if _, err := conn.Write([]byte("ERROR: Invalid JSON\n")); err != nil {
-			if s.verbose {
-				log.Printf("Error writing error response: %v", err)
-			}
-		}
-		return
+		log.Debug("Error parsing JSON", "error", err, "data", data)
+		return result{}, outcomeInvalidJSON, err
 	}
-
-	if req.Title == "" || req.Message == "" {
-		if _, err := conn.Write([]byte("ERROR: Missing title or message\n")); err != nil {
-			if s.verbose {
-				log.Printf("Error writing error response: %v", err)
-			}
-		}
-		return
+	if req.Version == protocolV1 || req.Version == protocolV2 {
+		*version = req.Version
 	}
+	log.Debug("Received request", "request", req)
 
-	if err := s.sendNotification(req.Title, req.Message, req.Sound); err != nil {
-		if s.verbose {
-			log.Printf("Error sending notification: %v", err)
-		}
-		if _, err := fmt.Fprintf(conn, "ERROR: %v\n", err); err != nil {
-			if s.verbose {
-				log.Printf("Error writing error response: %v", err)
-			}
-		}
-		return
+	name, err := s.authenticate(req.Token, remote)
+	if err != nil {
+		return result{}, outcomeUnauthorized, err
 	}
-
-	if _, err := conn.Write([]byte("OK\n")); err != nil {
-		if s.verbose {
-			log.Printf("Error writing OK response: %v", err)
-		}
+	if err := s.checkRate(name, ip); err != nil {
+		return result{}, outcomeRateLimited, err
 	}
+
+	res, err := s.perform(req, origin{ip: ip, token: name})
+	return res, requestOutcome(res, err), err
 }
 
-func (s *Server) sendNotification(title, message, sound string) error {
-	args := []string{
-		"-title", title,
-		"-message", message,
-		"-sender", "com.ahacop.macos-notify-bridge",
-	}
-	if sound != "" {
-		args = append(args, "-sound", sound)
-	}
+// Result statuses reported to clients.
+const (
+	statusOK        = "ok"
+	statusQueued    = "queued"
+	statusDuplicate = "duplicate"
+	statusDropped   = "dropped"
+	statusScheduled = "scheduled"
+	statusExpired   = "expired"
+)
 
-	cmd := exec.Command("terminal-notifier", args...)
+// result describes a successfully performed request: its status, the ID
+// assigned to a notification, and any data the action produced.
+type result struct {
+	status string
+	id     string
+	data   any
+}
 
-	if s.verbose {
-		output, err := cmd.CombinedOutput()
+// perform validates req and carries out its action. It is shared by the TCP
+// and HTTP front ends.
+func (s *Server) perform(req NotificationRequest, o origin) (result, error) {
+	if err := req.validate(); err != nil {
+		return result{}, err
+	}
+
+	switch req.Action {
+	case actionRemove:
+		if err := s.removeGroup(req.Group); err != nil {
+			return result{}, err
+		}
+		return result{status: statusOK}, nil
+	case actionList:
+		notifications, err := s.listGroup(req.Group)
 		if err != nil {
-			return fmt.Errorf("terminal-notifier failed: %w, output: %s", err, string(output))
+			return result{}, err
 		}
-		log.Printf("Notification sent: %s - %s (sound: %s)", title, message, sound)
-	} else {
-		if err := cmd.Run(); err != nil {
-			return fmt.Errorf("terminal-notifier failed: %w", err)
+		return result{status: statusOK, data: notifications}, nil
+	case actionCancel:
+		if err := s.cancel(req.ID); err != nil {
+			return result{}, err
+		}
+		return result{status: statusOK, id: req.ID}, nil
+	case actionPing:
+		return result{status: statusOK, data: s.ping()}, nil
+	case actionHistory:
+		entries, err := s.queryHistory(req)
+		if err != nil {
+			return result{}, err
 		}
+		return result{status: statusOK, data: entries}, nil
+	default:
+		return s.enqueue(req, o)
 	}
+}
+
+// deliver hands a validated notification to the notifier backend, retrying
+// failures according to the retry policy, unless it has expired. It runs on
+// a delivery queue worker.
+func (s *Server) deliver(id string, req NotificationRequest) error {
+	notifier, retry := s.currentNotifier(), s.currentRetry()
+	for attempt := 1; ; attempt++ {
+		if req.expired(time.Now()) {
+			s.dropExpired(id, req)
+			return errExpired
+		}
+		start := time.Now()
+		err := notifier.Notify(req)
+		s.metrics.observeNotify(notifier.Name(), time.Since(start))
+		if err == nil {
+			s.log.Debug("Notification delivered", "request_id", id, "duration_ms", float64(time.Since(start).Microseconds())/1000)
+			break
+		}
+		if attempt >= retry.attempts {
+			s.log.Warn("Error sending notification", "request_id", id, "attempts", attempt, "error", err)
+			s.forgetDuplicate(req, id)
+			s.updateHistory(id, historyFailed, err)
+			s.addDeadLetter(id, req, attempt, err)
+			return err
+		}
 
+		delay := retry.backoff(attempt)
+		s.log.Debug("Error sending notification, retrying", "request_id", id, "attempt", attempt, "attempts", retry.attempts, "retry_in", delay, "error", err)
+		select {
+		case <-time.After(delay):
+		case <-s.shutdown:
+			// Leave it in the spool for the next start
+			return err
+		}
+	}
+	if s.spool != nil {
+		s.spool.remove(id)
+	}
+	s.updateHistory(id, historyDelivered, nil)
 	return nil
 }
 
+// subcommands are run by name in place of the server, with the remaining
+// arguments, and return the process exit code.
+var subcommands = map[string]func(args []string) int{
+	"dead-letter": runDeadLetter,
+	"history":     runHistory,
+	"config":      runConfig,
+	"healthcheck": runHealthcheck,
+}
+
 func main() {
-	var (
-		port        = flag.Int("port", 9876, "Port to listen on")
-		portP       = flag.Int("p", 9876, "Port to listen on (short)")
-		host        = flag.String("host", "0.0.0.0", "Host to bind to")
-		hostH       = flag.String("h", "0.0.0.0", "Host to bind to (short)")
-		verbose     = flag.Bool("verbose", false, "Enable verbose logging")
-		verboseV    = flag.Bool("v", false, "Enable verbose logging (short)")
-		showVersion = flag.Bool("version", false, "Show version")
-	)
-
-	flag.Parse()
-
-	if *showVersion {
+	// Subcommands run instead of the server
+	if len(os.Args) > 1 {
+		if cmd, ok := subcommands[os.Args[1]]; ok {
+			os.Exit(cmd(os.Args[2:]))
+		}
+	}
+
+	st := &settings{}
+	fs := st.flagSet(os.Args[0], flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage of %s:\n", os.Args[0])
+		fs.PrintDefaults()
+		metricsHelp(fs.Output())
+	}
+	if err := st.load(fs, os.Args[1:], os.Getenv); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	if st.showVersion {
 		fmt.Printf("macos-notify-bridge version %s\n", version)
 		os.Exit(0)
 	}
 
-	// Use short flags if they were explicitly set
-	if isFlagPassed("p") {
-		*port = *portP
-	}
-	if isFlagPassed("h") {
-		*host = *hostH
+	logger, level, err := st.logger(os.Stderr)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
 	}
-	if isFlagPassed("v") {
-		*verbose = *verboseV
+	slog.SetDefault(logger)
+	debug := level <= slog.LevelDebug
+	if st.configFile != "" {
+		logger.Info("Loaded config file", "path", st.configFile)
 	}
 
-	// Check if terminal-notifier is available
-	if _, err := exec.LookPath("terminal-notifier"); err != nil {
-		log.Fatal("terminal-notifier not found. Please install it: brew install terminal-notifier")
+	notifier, err := NewNotifier(st.backend, NotifierOptions{Verbose: debug})
+	if err != nil {
+		fatal(err)
 	}
 
-	// Check for PORT environment variable
-	if envPort := os.Getenv("PORT"); envPort != "" && !isFlagPassed("port") && !isFlagPassed("p") {
-		var p int
-		if _, err := fmt.Sscanf(envPort, "%d", &p); err == nil {
-			*port = p
-		}
+	// Check that the selected backend can deliver notifications
+	if err := notifier.Available(); err != nil {
+		fatal(err)
 	}
 
-	server := NewServer(*host, *port, *verbose)
-	if err := server.Start(); err != nil {
-		log.Fatal(err)
+	opts, err := st.options()
+	if err != nil {
+		fatal(err)
 	}
-}
+	stores, err := st.openStores()
+	if err != nil {
+		fatal(err)
+	}
+	opts = append(opts, stores...)
+	opts = append(opts, WithNotifier(notifier), WithLogger(logger))
+	server := NewServer(st.host, st.port, debug, opts...)
+	if server.tokens.len() > 0 {
+		logger.Info("Token authentication enabled", "tokens", server.tokens.len())
+	}
+
+	// Shut down on SIGINT/SIGTERM and reload on SIGHUP
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
 
-func isFlagPassed(name string) bool {
-	found := false
-	flag.Visit(func(f *flag.Flag) {
-		if f.Name == name {
-			found = true
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		<-server.Ready()
+		for range hup {
+			st = reloadSettings(server, st, os.Args[1:], os.Getenv)
 		}
-	})
-	return found
+	}()
+
+	if err := server.Run(ctx); err != nil {
+		fatal(err)
+	}
 }
@@ -1,9 +1,11 @@
 package main
 
 import (
+	"bufio"
+	"context"
 	"encoding/json"
-	"flag"
 	"fmt"
+	"io"
 	"net"
 	"os"
 	"strings"
@@ -141,40 +143,6 @@ func TestNotificationRequestJSON(t *testing.T) {
 	}
 }
 
-func TestIsFlagPassed(t *testing.T) {
-	// Save original command line flags
-	oldCommandLine := flag.CommandLine
-	oldArgs := os.Args
-
-	// Create new flag set for testing
-	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
-
-	// Define test flags
-	flag.Int("testport", 9876, "test port")
-	flag.String("testhost", "localhost", "test host")
-	flag.Bool("testverbose", false, "test verbose")
-
-	// Test with flags passed
-	os.Args = []string{"cmd", "-testport=8080", "-testhost=127.0.0.1"}
-	if err := flag.CommandLine.Parse(os.Args[1:]); err != nil {
-		t.Fatalf("failed to parse flags: %v", err)
-	}
-
-	if !isFlagPassed("testport") {
-		t.Error("expected testport to be passed")
-	}
-	if !isFlagPassed("testhost") {
-		t.Error("expected testhost to be passed")
-	}
-	if isFlagPassed("testverbose") {
-		t.Error("expected testverbose to NOT be passed")
-	}
-
-	// Restore original flags
-	flag.CommandLine = oldCommandLine
-	os.Args = oldArgs
-}
-
 func TestHandleConnectionLogic(t *testing.T) {
 	tests := []struct {
 		name           string
@@ -290,37 +258,64 @@ func TestHandleConnectionLogic(t *testing.T) {
 	}
 }
 
-func TestServerStartStop(t *testing.T) {
+// runServer runs s in the background until the test ends and waits for it
+// to become ready.
+func runServer(t *testing.T, s *Server) {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- s.Run(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-serverErr; err != nil {
+			t.Errorf("server error: %v", err)
+		}
+	})
+
+	select {
+	case <-s.Ready():
+	case err := <-serverErr:
+		t.Fatalf("server failed to start: %v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("server did not become ready")
+	}
+}
+
+func TestServerRunStop(t *testing.T) {
 	if testing.Short() {
 		t.Skip("skipping test in short mode")
 	}
 
-	// Find an available port
-	listener, err := net.Listen("tcp", "localhost:0")
-	if err != nil {
-		t.Fatalf("failed to find available port: %v", err)
+	server := NewServer("localhost", 0, false)
+	if server.Addr() != nil {
+		t.Error("expected no address before the server is running")
 	}
-	port := listener.Addr().(*net.TCPAddr).Port
-	if err := listener.Close(); err != nil {
-		t.Logf("failed to close listener: %v", err)
-	}
-
-	server := NewServer("localhost", port, false)
 
-	// Start server in goroutine
+	ctx, cancel := context.WithCancel(context.Background())
 	serverErr := make(chan error, 1)
 	go func() {
-		err := server.Start()
-		if err != nil {
-			serverErr <- err
-		}
+		serverErr <- server.Run(ctx)
 	}()
 
-	// Give server time to start
-	time.Sleep(100 * time.Millisecond)
+	select {
+	case <-server.Ready():
+	case err := <-serverErr:
+		t.Fatalf("server failed to start: %v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("server did not become ready")
+	}
+
+	// Port 0 resolves to a real port
+	addr := server.Addr()
+	if addr == nil || addr.(*net.TCPAddr).Port == 0 {
+		t.Fatalf("expected a bound address, got %v", addr)
+	}
 
 	// Check if server is listening
-	conn, err := net.Dial("tcp", fmt.Sprintf("localhost:%d", port))
+	conn, err := net.Dial("tcp", addr.String())
 	if err != nil {
 		t.Fatalf("server not listening: %v", err)
 	}
@@ -328,22 +323,45 @@ func TestServerStartStop(t *testing.T) {
 		t.Logf("failed to close connection: %v", err)
 	}
 
-	// Stop server
-	server.Stop()
-
-	// Check for server errors
+	// Cancelling the context stops the server
+	cancel()
 	select {
 	case err := <-serverErr:
-		t.Fatalf("server error: %v", err)
-	case <-time.After(time.Second):
-		// No error, server stopped gracefully
+		if err != nil {
+			t.Fatalf("server error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("server did not stop after context cancellation")
 	}
 
 	// Verify server is no longer listening
-	_, err = net.Dial("tcp", fmt.Sprintf("localhost:%d", port))
+	_, err = net.Dial("tcp", addr.String())
 	if err == nil {
 		t.Error("server still listening after stop")
 	}
+
+	// Stopping again is a no-op
+	server.Stop()
+}
+
+func TestServerStopEndsRun(t *testing.T) {
+	server := NewServer("localhost", 0, false, WithNotifier(&fakeNotifier{}))
+
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- server.Run(context.Background())
+	}()
+	<-server.Ready()
+
+	server.Stop()
+	select {
+	case err := <-serverErr:
+		if err != nil {
+			t.Errorf("server error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after Stop")
+	}
 }
 
 func TestSendNotificationWithSender(t *testing.T) {
@@ -375,32 +393,12 @@ func TestSendNotificationWithSender(t *testing.T) {
 		}
 	})
 
-	// Find available port
-	listener, err := net.Listen("tcp", "localhost:0")
-	if err != nil {
-		t.Fatalf("failed to find available port: %v", err)
-	}
-	port := listener.Addr().(*net.TCPAddr).Port
-	if err := listener.Close(); err != nil {
-		t.Logf("failed to close listener: %v", err)
-	}
-
 	// Create and start server
-	server := NewServer("localhost", port, false)
-	go func() {
-		if err := server.Start(); err != nil {
-			t.Logf("server error: %v", err)
-		}
-	}()
-	t.Cleanup(func() {
-		server.Stop()
-	})
-
-	// Give server time to start
-	time.Sleep(100 * time.Millisecond)
+	server := NewServer("localhost", 0, false)
+	runServer(t, server)
 
 	// Send a notification
-	conn, err := net.Dial("tcp", fmt.Sprintf("localhost:%d", port))
+	conn, err := net.Dial("tcp", server.Addr().String())
 	if err != nil {
 		t.Fatalf("failed to connect: %v", err)
 	}
@@ -411,9 +409,12 @@ func TestSendNotificationWithSender(t *testing.T) {
 	}()
 
 	notification := NotificationRequest{
-		Title:   "Test Title",
-		Message: "Test Message",
-		Sound:   "Basso",
+		Title:    "Test Title",
+		Message:  "Test Message",
+		Sound:    "Basso",
+		Subtitle: "Test Subtitle",
+		Group:    "ci",
+		Open:     "https://ci.example.com/build/1",
 	}
 	data, _ := json.Marshal(notification)
 
@@ -454,6 +455,9 @@ func TestSendNotificationWithSender(t *testing.T) {
 	if !strings.Contains(logData, "Sound: Basso") {
 		t.Errorf("expected sound not found in log: %s", logData)
 	}
+	if !strings.Contains(logData, "Subtitle: Test Subtitle, Group: ci, Open: https://ci.example.com/build/1") {
+		t.Errorf("expected subtitle, group and open not found in log: %s", logData)
+	}
 }
 
 func TestConcurrentConnections(t *testing.T) {
@@ -481,30 +485,9 @@ func TestConcurrentConnections(t *testing.T) {
 		}
 	})
 
-	// Find an available port
-	listener, err := net.Listen("tcp", "localhost:0")
-	if err != nil {
-		t.Fatalf("failed to find available port: %v", err)
-	}
-	port := listener.Addr().(*net.TCPAddr).Port
-	if err := listener.Close(); err != nil {
-		t.Logf("failed to close listener: %v", err)
-	}
-
-	server := NewServer("localhost", port, false)
-
-	// Start server
-	go func() {
-		if err := server.Start(); err != nil {
-			t.Logf("server start error: %v", err)
-		}
-	}()
-	t.Cleanup(func() {
-		server.Stop()
-	})
-
-	// Give server time to start
-	time.Sleep(100 * time.Millisecond)
+	server := NewServer("localhost", 0, false)
+	runServer(t, server)
+	addr := server.Addr().String()
 
 	// Send multiple concurrent connections
 	numConnections := 10
@@ -512,7 +495,7 @@ func TestConcurrentConnections(t *testing.T) {
 
 	for i := 0; i < numConnections; i++ {
 		go func(id int) {
-			conn, err := net.Dial("tcp", fmt.Sprintf("localhost:%d", port))
+			conn, err := net.Dial("tcp", addr)
 			if err != nil {
 				t.Errorf("connection %d failed: %v", id, err)
 				done <- false
@@ -569,3 +552,82 @@ func TestConcurrentConnections(t *testing.T) {
 		t.Errorf("only %d/%d connections succeeded", successCount, numConnections)
 	}
 }
+
+func TestPersistentConnection(t *testing.T) {
+	fake := &fakeNotifier{}
+	s := NewServer("localhost", 0, false, WithNotifier(fake))
+
+	client, server := net.Pipe()
+	t.Cleanup(func() {
+		if err := client.Close(); err != nil {
+			t.Logf("failed to close client: %v", err)
+		}
+	})
+
+	s.wg.Add(1)
+	go s.handleConnection(server)
+
+	if err := client.SetDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		t.Fatalf("failed to set deadline: %v", err)
+	}
+
+	requests := []struct {
+		line     string
+		response string
+	}{
+		{`{"title":"First","message":"1"}`, "OK"},
+		{`invalid json`, "ERROR: Invalid JSON"},
+		{`{"title":"Second"}`, "ERROR: Missing title or message"},
+		{`{"title":"Third","message":"3"}`, "OK"},
+	}
+
+	reader := bufio.NewReader(client)
+	for _, r := range requests {
+		if _, err := client.Write([]byte(r.line + "\n")); err != nil {
+			t.Fatalf("failed to write %q: %v", r.line, err)
+		}
+		got, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("failed to read response to %q: %v", r.line, err)
+		}
+		if strings.TrimSpace(got) != r.response {
+			t.Errorf("request %q: expected %q, got %q", r.line, r.response, strings.TrimSpace(got))
+		}
+	}
+
+	reqs := fake.requests()
+	if len(reqs) != 2 || reqs[0].Title != "First" || reqs[1].Title != "Third" {
+		t.Errorf("expected First and Third to be delivered in order, got %+v", reqs)
+	}
+}
+
+func TestIdleTimeoutClosesConnection(t *testing.T) {
+	s := NewServer("localhost", 0, false, WithNotifier(&fakeNotifier{}), WithIdleTimeout(50*time.Millisecond))
+
+	client, server := net.Pipe()
+	t.Cleanup(func() {
+		if err := client.Close(); err != nil {
+			t.Logf("failed to close client: %v", err)
+		}
+	})
+
+	s.wg.Add(1)
+	go s.handleConnection(server)
+
+	if err := client.SetDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		t.Fatalf("failed to set deadline: %v", err)
+	}
+
+	reader := bufio.NewReader(client)
+	if _, err := client.Write([]byte(`{"title":"Test","message":"Hello"}` + "\n")); err != nil {
+		t.Fatalf("failed to write request: %v", err)
+	}
+	if got, err := reader.ReadString('\n'); err != nil || got != "OK\n" {
+		t.Fatalf("expected OK, got %q (err: %v)", got, err)
+	}
+
+	// After the idle timeout the server closes without writing an error
+	if got, err := reader.ReadString('\n'); err != io.EOF {
+		t.Errorf("expected EOF after idle timeout, got %q (err: %v)", got, err)
+	}
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Request outcomes counted by mnb_requests_total, beyond the result
+// statuses of successful requests.
+const (
+	outcomeInvalidJSON    = "invalid_json"
+	outcomeMissingFields  = "missing_fields"
+	outcomeInvalid        = "invalid_request"
+	outcomeUnauthorized   = "unauthorized"
+	outcomeRateLimited    = "rate_limited"
+	outcomeNotFound       = "not_found"
+	outcomeUnsupported    = "unsupported"
+	outcomeUnavailable    = "unavailable"
+	outcomeBackendFailure = "backend_failure"
+)
+
+// requestOutcomes lists every outcome so each is exported from the start.
+var requestOutcomes = []string{
+	statusOK, statusQueued, statusDuplicate, statusDropped, statusScheduled, statusExpired,
+	outcomeInvalidJSON, outcomeMissingFields, outcomeInvalid, outcomeUnauthorized, outcomeRateLimited,
+	outcomeNotFound, outcomeUnsupported, outcomeUnavailable, outcomeBackendFailure,
+}
+
+// notifyBuckets are the upper bounds, in seconds, of the notifier latency
+// histogram.
+var notifyBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// metricInfo describes an exported metric.
+type metricInfo struct {
+	name string
+	kind string
+	help string
+}
+
+// metricInfos lists the exported metrics. Their names are stable.
+var metricInfos = []metricInfo{
+	{"mnb_connections_accepted_total", "counter", "TCP and Unix socket connections accepted."},
+	{"mnb_active_connections", "gauge", "TCP and Unix socket connections currently open."},
+	{"mnb_requests_total", "counter", "Requests handled, by outcome."},
+	{"mnb_notifier_duration_seconds", "histogram", "Time taken by each call to the notification backend, by backend."},
+	{"mnb_queue_depth", "gauge", "Notifications waiting for a delivery worker."},
+	{"mnb_queue_capacity", "gauge", "Notifications that may wait for a delivery worker."},
+	{"mnb_scheduled_notifications", "gauge", "Notifications scheduled for later delivery."},
+}
+
+// metrics counts what the server does for the /metrics endpoint.
+type metrics struct {
+	connections atomic.Int64
+	active      atomic.Int64
+
+	mu       sync.Mutex
+	requests map[string]int64
+	notify   map[string]*histogram
+}
+
+// histogram counts observations of the notifier latency for one backend.
+type histogram struct {
+	buckets []int64
+	sum     float64
+	count   int64
+}
+
+func newMetrics() *metrics {
+	m := &metrics{
+		requests: make(map[string]int64),
+		notify:   make(map[string]*histogram),
+	}
+	for _, outcome := range requestOutcomes {
+		m.requests[outcome] = 0
+	}
+	return m
+}
+
+// WithMetricsAddr serves Prometheus metrics at /metrics on addr, given as
+// host:port. An empty addr leaves the endpoint disabled.
+func WithMetricsAddr(addr string) Option {
+	return func(s *Server) {
+		s.metricsAddr = addr
+	}
+}
+
+// countRequest records a request with the given outcome.
+func (m *metrics) countRequest(outcome string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests[outcome]++
+}
+
+// observeNotify records how long a call to the named backend took.
+func (m *metrics) observeNotify(backend string, d time.Duration) {
+	seconds := d.Seconds()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	h := m.notifyHistogramLocked(backend)
+	for i, bound := range notifyBuckets {
+		if seconds <= bound {
+			h.buckets[i]++
+		}
+	}
+	h.sum += seconds
+	h.count++
+}
+
+// notifyHistogramLocked returns the latency histogram for backend, adding
+// an empty one if it has none yet.
+func (m *metrics) notifyHistogramLocked(backend string) *histogram {
+	h, ok := m.notify[backend]
+	if !ok {
+		h = &histogram{buckets: make([]int64, len(notifyBuckets))}
+		m.notify[backend] = h
+	}
+	return h
+}
+
+// requestOutcome classifies a performed request for mnb_requests_total.
+func requestOutcome(res result, err error) string {
+	switch {
+	case err == nil:
+		return res.status
+	case errors.Is(err, errMissingFields):
+		return outcomeMissingFields
+	case isValidationError(err):
+		return outcomeInvalid
+	case errors.Is(err, errNotFound):
+		return outcomeNotFound
+	case errors.Is(err, errUnsupported), errors.Is(err, errHistoryDisabled), errors.Is(err, errScheduleNoSpool):
+		return outcomeUnsupported
+	case errors.Is(err, errQueueFull), errors.Is(err, errQueueClosed), errors.Is(err, errSpoolFull):
+		return outcomeUnavailable
+	default:
+		return outcomeBackendFailure
+	}
+}
+
+// writeMetrics writes the server's metrics in the Prometheus text format.
+func (s *Server) writeMetrics(w io.Writer) {
+	m := s.metrics
+	header := func(i int) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", metricInfos[i].name, metricInfos[i].help, metricInfos[i].name, metricInfos[i].kind)
+	}
+
+	header(0)
+	fmt.Fprintf(w, "mnb_connections_accepted_total %d\n", m.connections.Load())
+	header(1)
+	fmt.Fprintf(w, "mnb_active_connections %d\n", m.active.Load())
+
+	m.mu.Lock()
+	outcomes := make([]string, 0, len(m.requests))
+	for outcome := range m.requests {
+		outcomes = append(outcomes, outcome)
+	}
+	sort.Strings(outcomes)
+	header(2)
+	for _, outcome := range outcomes {
+		fmt.Fprintf(w, "mnb_requests_total{outcome=%q} %d\n", outcome, m.requests[outcome])
+	}
+
+	// The current backend is exported even before its first call; backends
+	// used before a reload keep their own series
+	m.notifyHistogramLocked(s.currentNotifier().Name())
+	backends := make([]string, 0, len(m.notify))
+	for backend := range m.notify {
+		backends = append(backends, backend)
+	}
+	sort.Strings(backends)
+	header(3)
+	for _, backend := range backends {
+		h := m.notify[backend]
+		for i, bound := range notifyBuckets {
+			fmt.Fprintf(w, "mnb_notifier_duration_seconds_bucket{backend=%q,le=%q} %d\n", backend, strconv.FormatFloat(bound, 'g', -1, 64), h.buckets[i])
+		}
+		fmt.Fprintf(w, "mnb_notifier_duration_seconds_bucket{backend=%q,le=\"+Inf\"} %d\n", backend, h.count)
+		fmt.Fprintf(w, "mnb_notifier_duration_seconds_sum{backend=%q} %g\n", backend, h.sum)
+		fmt.Fprintf(w, "mnb_notifier_duration_seconds_count{backend=%q} %d\n", backend, h.count)
+	}
+	m.mu.Unlock()
+
+	header(4)
+	fmt.Fprintf(w, "mnb_queue_depth %d\n", s.queue.len())
+	header(5)
+	fmt.Fprintf(w, "mnb_queue_capacity %d\n", cap(s.queue.jobs))
+	header(6)
+	fmt.Fprintf(w, "mnb_scheduled_notifications %d\n", s.scheduler.len())
+}
+
+func (s *Server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	s.writeMetrics(w)
+}
+
+func (s *Server) startMetrics() error {
+	listener, err := net.Listen("tcp", s.metricsAddr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", s.metricsAddr, err)
+	}
+	s.serveMetrics(listener)
+	return nil
+}
+
+// serveMetrics serves /metrics on listener in place of the current metrics
+// server, which it returns. A nil listener stops serving metrics.
+func (s *Server) serveMetrics(listener net.Listener) *http.Server {
+	var metricsServer *http.Server
+	if listener != nil {
+		s.log.Info("Metrics listening", "addr", listener.Addr().String())
+		mux := http.NewServeMux()
+		mux.HandleFunc("/metrics", s.handleMetrics)
+		metricsServer = &http.Server{
+			Handler:           mux,
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+	}
+	return s.replaceHTTPServer(&s.metricsServer, metricsServer, listener)
+}
+
+// metricsHelp describes the exported metrics for the usage message.
+func metricsHelp(w io.Writer) {
+	fmt.Fprintln(w, "\nMetrics served at /metrics with --metrics-addr:")
+	for _, info := range metricInfos {
+		fmt.Fprintf(w, "  %s (%s)\n    \t%s\n", info.name, info.kind, info.help)
+	}
+	fmt.Fprintf(w, "  Outcomes of mnb_requests_total: %s\n", strings.Join(requestOutcomes, ", "))
+}
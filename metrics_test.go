@@ -0,0 +1,158 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRequestOutcome(t *testing.T) {
+	tests := []struct {
+		res  result
+		err  error
+		want string
+	}{
+		{result{status: statusOK}, nil, "ok"},
+		{result{status: statusQueued}, nil, "queued"},
+		{result{}, errMissingFields, "missing_fields"},
+		{result{}, fieldErrors{{"open", "bad"}}, "invalid_request"},
+		{result{}, errNotFound, "not_found"},
+		{result{}, fmt.Errorf("list %w x", errUnsupported), "unsupported"},
+		{result{}, errQueueFull, "unavailable"},
+		{result{}, errors.New("terminal-notifier failed"), "backend_failure"},
+	}
+	for _, tt := range tests {
+		if got := requestOutcome(tt.res, tt.err); got != tt.want {
+			t.Errorf("requestOutcome(%+v, %v): expected %q, got %q", tt.res, tt.err, tt.want, got)
+		}
+	}
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	fake := &fakeNotifier{}
+	s := NewServer("localhost", 0, false, WithNotifier(fake), WithRetry(1, 0, 0))
+	t.Cleanup(s.Stop)
+
+	roundTrip(t, s, `{"title":"T","message":"M"}`)
+	roundTrip(t, s, `{"title":"T","message":"M"}`)
+	roundTrip(t, s, `not json`)
+	roundTrip(t, s, `{"title":"T"}`)
+	fake.mu.Lock()
+	fake.err = errors.New("backend down")
+	fake.mu.Unlock()
+	roundTrip(t, s, `{"title":"T","message":"M"}`)
+
+	rec := httptest.NewRecorder()
+	s.handleMetrics(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("expected text/plain, got %q", ct)
+	}
+	body := rec.Body.String()
+
+	for _, want := range []string{
+		"mnb_connections_accepted_total 0\n",
+		`mnb_requests_total{outcome="ok"} 2`,
+		`mnb_requests_total{outcome="invalid_json"} 1`,
+		`mnb_requests_total{outcome="missing_fields"} 1`,
+		`mnb_requests_total{outcome="backend_failure"} 1`,
+		`mnb_requests_total{outcome="rate_limited"} 0`,
+		`mnb_notifier_duration_seconds_bucket{backend="fake",le="+Inf"} 3`,
+		`mnb_notifier_duration_seconds_count{backend="fake"} 3`,
+		"mnb_queue_depth 0\n",
+		"mnb_queue_capacity 256\n",
+		"mnb_scheduled_notifications 0\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected metrics to contain %q, got:\n%s", want, body)
+		}
+	}
+	for _, info := range metricInfos {
+		if !strings.Contains(body, "# TYPE "+info.name+" "+info.kind+"\n") {
+			t.Errorf("expected TYPE line for %s", info.name)
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	s.handleMetrics(rec, httptest.NewRequest(http.MethodPost, "/metrics", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected 405 for POST, got %d", rec.Code)
+	}
+}
+
+func TestMetricsCountsConnections(t *testing.T) {
+	s := NewServer("localhost", 0, false, WithNotifier(&fakeNotifier{}), WithMetricsAddr("127.0.0.1:0"))
+	runServer(t, s)
+
+	client := &clientOptions{addr: s.Addr().String(), timeout: 5 * time.Second}
+	if resp, err := client.send(NotificationRequest{Title: "T", Message: "M"}); err != nil || resp != "OK" {
+		t.Fatalf("expected OK, got %q (%v)", resp, err)
+	}
+
+	// The connection is counted once accepted; it is no longer active once
+	// the server has seen it close
+	deadline := time.Now().Add(2 * time.Second)
+	for s.metrics.active.Load() != 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := s.metrics.connections.Load(); n != 1 {
+		t.Errorf("expected 1 accepted connection, got %d", n)
+	}
+	if n := s.metrics.active.Load(); n != 0 {
+		t.Errorf("expected no active connections, got %d", n)
+	}
+
+	s.mu.Lock()
+	started := s.metricsServer != nil
+	s.mu.Unlock()
+	if !started {
+		t.Error("expected the metrics server to be started")
+	}
+}
+
+// namedNotifier is a fake backend with a name of its own.
+type namedNotifier struct {
+	*fakeNotifier
+	name string
+}
+
+func (n namedNotifier) Name() string { return n.name }
+
+func TestMetricsNotifierDurationByBackend(t *testing.T) {
+	s := NewServer("localhost", 0, false, WithNotifier(&fakeNotifier{}))
+	t.Cleanup(s.Stop)
+	roundTrip(t, s, `{"title":"T","message":"one"}`)
+	roundTrip(t, s, `{"title":"T","message":"two"}`)
+
+	// Switching backends starts a new series and keeps the old one
+	if err := s.Reconfigure("localhost", 0, WithNotifier(namedNotifier{&fakeNotifier{}, "other"})); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	rec := httptest.NewRecorder()
+	s.handleMetrics(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := rec.Body.String()
+	for _, want := range []string{
+		`mnb_notifier_duration_seconds_count{backend="fake"} 2`,
+		`mnb_notifier_duration_seconds_count{backend="other"} 0`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected metrics to contain %q, got:\n%s", want, body)
+		}
+	}
+
+	roundTrip(t, s, `{"title":"T","message":"three"}`)
+	rec = httptest.NewRecorder()
+	s.handleMetrics(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body = rec.Body.String()
+	for _, want := range []string{
+		`mnb_notifier_duration_seconds_count{backend="fake"} 2`,
+		`mnb_notifier_duration_seconds_count{backend="other"} 1`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected metrics to contain %q, got:\n%s", want, body)
+		}
+	}
+}
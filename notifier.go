@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os/exec"
+	"sort"
+	"sync"
+)
+
+// defaultBackend is the notifier backend used when none is selected.
+const defaultBackend = "terminal-notifier"
+
+// Notifier delivers notifications to the user.
+type Notifier interface {
+	// Name returns the registry name of the backend.
+	Name() string
+	// Available reports whether the backend can deliver notifications on
+	// this machine, returning an error describing what is missing if not.
+	Available() error
+	// Notify delivers a single notification.
+	Notify(req NotificationRequest) error
+}
+
+// NotifierOptions holds settings shared by all notifier backends.
+type NotifierOptions struct {
+	Verbose bool
+}
+
+// NotifierFactory creates a Notifier from the given options.
+type NotifierFactory func(opts NotifierOptions) Notifier
+
+var (
+	notifiersMu sync.RWMutex
+	notifiers   = make(map[string]NotifierFactory)
+)
+
+// RegisterNotifier makes a notifier backend available under the given name.
+// It panics if the name is empty, the factory is nil, or the name is already
+// registered.
+func RegisterNotifier(name string, factory NotifierFactory) {
+	notifiersMu.Lock()
+	defer notifiersMu.Unlock()
+
+	if name == "" {
+		panic("notifier: empty backend name")
+	}
+	if factory == nil {
+		panic("notifier: nil factory for backend " + name)
+	}
+	if _, dup := notifiers[name]; dup {
+		panic("notifier: backend registered twice: " + name)
+	}
+	notifiers[name] = factory
+}
+
+// NewNotifier creates the notifier backend registered under name.
+func NewNotifier(name string, opts NotifierOptions) (Notifier, error) {
+	notifiersMu.RLock()
+	factory, ok := notifiers[name]
+	notifiersMu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown backend %q (available: %v)", name, NotifierNames())
+	}
+	return factory(opts), nil
+}
+
+// NotifierNames returns the sorted names of all registered backends.
+func NotifierNames() []string {
+	notifiersMu.RLock()
+	defer notifiersMu.RUnlock()
+
+	names := make([]string, 0, len(notifiers))
+	for name := range notifiers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func init() {
+	RegisterNotifier(defaultBackend, func(opts NotifierOptions) Notifier {
+		return &terminalNotifier{verbose: opts.Verbose}
+	})
+}
+
+// terminalNotifier delivers notifications by running terminal-notifier.
+type terminalNotifier struct {
+	verbose bool
+}
+
+func (n *terminalNotifier) Name() string {
+	return defaultBackend
+}
+
+func (n *terminalNotifier) Available() error {
+	if _, err := exec.LookPath("terminal-notifier"); err != nil {
+		return fmt.Errorf("terminal-notifier not found. Please install it: brew install terminal-notifier")
+	}
+	return nil
+}
+
+func (n *terminalNotifier) Notify(req NotificationRequest) error {
+	cmd := exec.Command("terminal-notifier", n.args(req)...)
+
+	if n.verbose {
+		output, err := cmd.CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("terminal-notifier failed: %w, output: %s", err, string(output))
+		}
+		slog.Debug("Notification sent", "title", req.Title, "message", req.Message, "sound", req.Sound)
+	} else {
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("terminal-notifier failed: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// RemoveGroup withdraws the delivered notifications in group.
+func (n *terminalNotifier) RemoveGroup(group string) error {
+	output, err := exec.Command("terminal-notifier", "-remove", group).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("terminal-notifier failed: %w, output: %s", err, string(output))
+	}
+	return nil
+}
+
+// ListGroup reports the delivered notifications in group.
+func (n *terminalNotifier) ListGroup(group string) ([]DeliveredNotification, error) {
+	output, err := exec.Command("terminal-notifier", "-list", group).Output()
+	if err != nil {
+		return nil, fmt.Errorf("terminal-notifier failed: %w", err)
+	}
+	return parseNotificationList(string(output)), nil
+}
+
+func (n *terminalNotifier) args(req NotificationRequest) []string {
+	args := []string{
+		"-title", req.Title,
+		"-message", req.Message,
+		"-sender", "com.ahacop.macos-notify-bridge",
+	}
+	if req.Subtitle != "" {
+		args = append(args, "-subtitle", req.Subtitle)
+	}
+	if req.Sound != "" {
+		args = append(args, "-sound", req.Sound)
+	}
+	if req.Group != "" {
+		args = append(args, "-group", req.Group)
+	}
+	if req.Open != "" {
+		args = append(args, "-open", req.Open)
+	}
+	if req.Activate != "" {
+		args = append(args, "-activate", req.Activate)
+	}
+	if req.AppIcon != "" {
+		args = append(args, "-appIcon", req.AppIcon)
+	}
+	if req.ContentImage != "" {
+		args = append(args, "-contentImage", req.ContentImage)
+	}
+	if req.IgnoreDnD {
+		args = append(args, "-ignoreDnD")
+	}
+	return args
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeNotifier records delivered notifications instead of displaying them.
+type fakeNotifier struct {
+	mu        sync.Mutex
+	delivered []NotificationRequest
+	err       error
+}
+
+func (f *fakeNotifier) Name() string { return "fake" }
+
+func (f *fakeNotifier) Available() error { return nil }
+
+func (f *fakeNotifier) Notify(req NotificationRequest) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.err != nil {
+		return f.err
+	}
+	f.delivered = append(f.delivered, req)
+	return nil
+}
+
+func (f *fakeNotifier) requests() []NotificationRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]NotificationRequest(nil), f.delivered...)
+}
+
+// roundTrip writes a single line to a fresh connection handled by s and
+// returns the trimmed response.
+func roundTrip(t *testing.T, s *Server, line string) string {
+	t.Helper()
+
+	client, server := net.Pipe()
+	t.Cleanup(func() {
+		if err := client.Close(); err != nil {
+			t.Logf("failed to close client: %v", err)
+		}
+	})
+
+	s.wg.Add(1)
+	go s.handleConnection(server)
+
+	if err := client.SetDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		t.Fatalf("failed to set deadline: %v", err)
+	}
+	if _, err := client.Write([]byte(line + "\n")); err != nil {
+		t.Fatalf("failed to write request: %v", err)
+	}
+
+	response := make([]byte, 1024)
+	n, err := client.Read(response)
+	if err != nil {
+		t.Fatalf("failed to read response: %v", err)
+	}
+	return strings.TrimSpace(string(response[:n]))
+}
+
+func TestNotifierRegistry(t *testing.T) {
+	names := NotifierNames()
+	found := false
+	for _, name := range names {
+		if name == defaultBackend {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("expected %q in registered backends, got %v", defaultBackend, names)
+	}
+
+	n, err := NewNotifier(defaultBackend, NotifierOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n.Name() != defaultBackend {
+		t.Errorf("expected name %q, got %q", defaultBackend, n.Name())
+	}
+
+	if _, err := NewNotifier("no-such-backend", NotifierOptions{}); err == nil {
+		t.Error("expected error for unknown backend")
+	}
+}
+
+func TestRegisterNotifierDuplicatePanics(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("expected panic on duplicate registration")
+		}
+	}()
+	RegisterNotifier(defaultBackend, func(NotifierOptions) Notifier { return &fakeNotifier{} })
+}
+
+func TestTerminalNotifierArgs(t *testing.T) {
+	n := &terminalNotifier{}
+
+	args := n.args(NotificationRequest{Title: "T", Message: "M"})
+	want := []string{"-title", "T", "-message", "M", "-sender", "com.ahacop.macos-notify-bridge"}
+	if strings.Join(args, " ") != strings.Join(want, " ") {
+		t.Errorf("expected args %v, got %v", want, args)
+	}
+
+	args = n.args(NotificationRequest{Title: "T", Message: "M", Sound: "Hero"})
+	if args[len(args)-2] != "-sound" || args[len(args)-1] != "Hero" {
+		t.Errorf("expected trailing -sound Hero, got %v", args)
+	}
+}
+
+func TestServerDispatchesToNotifier(t *testing.T) {
+	fake := &fakeNotifier{}
+	s := NewServer("localhost", 0, false, WithNotifier(fake), WithRetry(1, 0, 0))
+
+	if got := roundTrip(t, s, `{"title":"Test","message":"Hello","sound":"Hero"}`); got != "OK" {
+		t.Fatalf("expected OK, got %q", got)
+	}
+
+	reqs := fake.requests()
+	if len(reqs) != 1 {
+		t.Fatalf("expected 1 delivered notification, got %d", len(reqs))
+	}
+	if reqs[0].Title != "Test" || reqs[0].Message != "Hello" || reqs[0].Sound != "Hero" {
+		t.Errorf("unexpected request delivered: %+v", reqs[0])
+	}
+
+	fake.mu.Lock()
+	fake.err = errors.New("backend down")
+	fake.mu.Unlock()
+	if got := roundTrip(t, s, `{"title":"Test","message":"Hello"}`); got != "ERROR: backend down" {
+		t.Errorf("expected backend error, got %q", got)
+	}
+}
+
+func TestTerminalNotifierRichArgs(t *testing.T) {
+	n := &terminalNotifier{}
+
+	args := n.args(NotificationRequest{
+		Title:        "T",
+		Message:      "M",
+		Subtitle:     "S",
+		Group:        "ci",
+		Open:         "https://ci.example.com",
+		Activate:     "com.apple.Terminal",
+		AppIcon:      "/tmp/icon.png",
+		ContentImage: "/tmp/image.png",
+		IgnoreDnD:    true,
+	})
+	got := strings.Join(args, " ")
+
+	for _, want := range []string{
+		"-subtitle S",
+		"-group ci",
+		"-open https://ci.example.com",
+		"-activate com.apple.Terminal",
+		"-appIcon /tmp/icon.png",
+		"-contentImage /tmp/image.png",
+		"-ignoreDnD",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("expected args to contain %q, got %v", want, args)
+		}
+	}
+}
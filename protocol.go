@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// Versions of the TCP response format. A request's "v" field selects the
+// version used for it and for later responses on the same connection.
+const (
+	// protocolV1 answers with text lines such as OK, QUEUED <id> and
+	// ERROR: <message>
+	protocolV1 = 1
+	// protocolV2 answers with a JSON NotificationResponse on each line
+	protocolV2 = 2
+)
+
+// outcomeReadFailed is the outcome of a request that could not be read,
+// such as one cut short by a timeout. It is not counted in the metrics.
+const outcomeReadFailed = "read_failed"
+
+// Codes of failed requests in JSON responses. They are part of the protocol,
+// so unlike the metric outcomes they map from, their names are stable.
+const (
+	codeInvalidJSON    = "invalid_json"
+	codeMissingField   = "missing_field"
+	codeInvalidRequest = "invalid_request"
+	codeUnauthorized   = "unauthorized"
+	codeRateLimited    = "rate_limited"
+	codeNotFound       = "not_found"
+	codeUnsupported    = "unsupported"
+	codeUnavailable    = "unavailable"
+	codeBackendFailed  = "backend_failed"
+	codeReadFailed     = "read_failed"
+)
+
+// responseCodes maps the outcome of a failed request to its response code.
+var responseCodes = map[string]string{
+	outcomeInvalidJSON:    codeInvalidJSON,
+	outcomeMissingFields:  codeMissingField,
+	outcomeInvalid:        codeInvalidRequest,
+	outcomeUnauthorized:   codeUnauthorized,
+	outcomeRateLimited:    codeRateLimited,
+	outcomeNotFound:       codeNotFound,
+	outcomeUnsupported:    codeUnsupported,
+	outcomeUnavailable:    codeUnavailable,
+	outcomeBackendFailure: codeBackendFailed,
+	outcomeReadFailed:     codeReadFailed,
+}
+
+// formatResponse formats the reply to a request handled with the given
+// outcome in the given protocol version.
+func formatResponse(version int, res result, outcome string, err error) string {
+	if version >= protocolV2 {
+		return v2Response(res, outcome, err)
+	}
+	return v1Response(res, outcome, err)
+}
+
+// v1Response formats a reply as a legacy text line.
+func v1Response(res result, outcome string, err error) string {
+	if err != nil {
+		switch {
+		case outcome == outcomeInvalidJSON:
+			return "ERROR: Invalid JSON\n"
+		case outcome == outcomeUnauthorized:
+			return "ERROR: Unauthorized\n"
+		case outcome == outcomeReadFailed:
+			return "ERROR: Failed to read request\n"
+		case errors.Is(err, errMissingFields):
+			return "ERROR: Missing title or message\n"
+		default:
+			return fmt.Sprintf("ERROR: %v\n", err)
+		}
+	}
+
+	switch {
+	case res.status == statusQueued:
+		return fmt.Sprintf("QUEUED %s\n", res.id)
+	case res.status == statusDuplicate:
+		return "OK duplicate\n"
+	case res.status == statusDropped:
+		return "OK dropped\n"
+	case res.status == statusScheduled:
+		return fmt.Sprintf("SCHEDULED %s\n", res.id)
+	case res.status == statusExpired:
+		return "EXPIRED\n"
+	case res.data != nil:
+		data, err := json.Marshal(res.data)
+		if err != nil {
+			return fmt.Sprintf("ERROR: %v\n", err)
+		}
+		return fmt.Sprintf("OK %s\n", data)
+	default:
+		return "OK\n"
+	}
+}
+
+// v2Response formats a reply as a JSON line. Failed requests have a status
+// of error, a code naming their outcome and a message saying what went
+// wrong.
+func v2Response(res result, outcome string, err error) string {
+	resp := NotificationResponse{Status: res.status, ID: res.id, Result: res.data}
+	if err != nil {
+		resp = errorResponse(outcome, err)
+	}
+	data, err := json.Marshal(resp)
+	if err != nil {
+		data, _ = json.Marshal(NotificationResponse{Status: "error", Code: codeBackendFailed, Error: err.Error()})
+	}
+	return string(data) + "\n"
+}
+
+// errorResponse describes a request that failed with the given outcome.
+func errorResponse(outcome string, err error) NotificationResponse {
+	message := err.Error()
+	if outcome == outcomeInvalidJSON {
+		message = "invalid JSON"
+	}
+	code, ok := responseCodes[outcome]
+	if !ok {
+		code = codeBackendFailed
+	}
+	return NotificationResponse{Status: "error", Code: code, Error: message}
+}
+
+// readFailedResponse formats the reply to a request that could not be read.
+func readFailedResponse(version int) string {
+	return formatResponse(version, result{}, outcomeReadFailed, errors.New("failed to read request"))
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestV2Responses(t *testing.T) {
+	tokens, err := buildTokenSet([]string{"ci:s3cret"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	s := NewServer("localhost", 0, false, WithNotifier(&fakeNotifier{}), WithTokens(tokens), WithDedupWindow(time.Minute), WithSpool(testSpool(t)))
+	t.Cleanup(s.Stop)
+
+	tests := []struct {
+		name       string
+		line       string
+		wantStatus string
+		wantCode   string
+		wantError  string
+		wantID     bool
+	}{
+		{"delivered", `{"v":2,"title":"T","message":"M","token":"s3cret"}`, "ok", "", "", true},
+		{"duplicate", `{"v":2,"title":"T","message":"M","token":"s3cret"}`, "duplicate", "", "", true},
+		{"scheduled", `{"v":2,"title":"T","message":"later","delay":"1h","token":"s3cret"}`, "scheduled", "", "", true},
+		{"unauthorized", `{"v":2,"title":"T","message":"M"}`, "error", "unauthorized", "unauthorized", false},
+		{"missing field", `{"v":2,"title":"T","token":"s3cret"}`, "error", "missing_field", "missing title or message", false},
+		{"invalid field", `{"v":2,"title":"T","message":"bad app","activate":"Terminal","token":"s3cret"}`, "error", "invalid_request", "invalid activate", false},
+		{"not found", `{"v":2,"action":"cancel","id":"nope","token":"s3cret"}`, "error", "not_found", "not found", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var resp NotificationResponse
+			got := roundTrip(t, s, tt.line)
+			if err := json.Unmarshal([]byte(got), &resp); err != nil {
+				t.Fatalf("expected a JSON response, got %q", got)
+			}
+			if resp.Status != tt.wantStatus || resp.Code != tt.wantCode || !strings.Contains(resp.Error, tt.wantError) {
+				t.Errorf("expected status %q, code %q and error %q, got %+v", tt.wantStatus, tt.wantCode, tt.wantError, resp)
+			}
+			if (resp.ID != "") != tt.wantID {
+				t.Errorf("expected id present=%v, got %+v", tt.wantID, resp)
+			}
+		})
+	}
+}
+
+func TestProtocolVersionPerConnection(t *testing.T) {
+	s := NewServer("localhost", 0, false, WithNotifier(&fakeNotifier{}))
+	t.Cleanup(s.Stop)
+
+	client, server := net.Pipe()
+	t.Cleanup(func() { _ = client.Close() })
+	s.wg.Add(1)
+	go s.handleConnection(server)
+	if err := client.SetDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		t.Fatalf("failed to set deadline: %v", err)
+	}
+	reader := bufio.NewReader(client)
+
+	for _, step := range []struct {
+		line string
+		want string
+	}{
+		// Legacy text until a request asks for version 2
+		{`not json`, "ERROR: Invalid JSON"},
+		{`{"v":2,"action":"ping"}`, `{"status":"ok","result":{`},
+		// Later requests get JSON even without "v", or if unreadable
+		{`not json`, `{"status":"error","code":"invalid_json","error":"invalid JSON"}`},
+		{`{"title":"T"}`, `{"status":"error","code":"missing_field","error":"missing title or message"}`},
+		{`{"v":3,"title":"T","message":"M"}`, `{"status":"error","code":"invalid_request","error":"invalid v: unsupported protocol version 3 (use 1 or 2)"}`},
+		// Version 1 switches back
+		{`{"v":1,"title":"T","message":"M"}`, "OK"},
+		{`{"title":"T"}`, "ERROR: Missing title or message"},
+	} {
+		if _, err := client.Write([]byte(step.line + "\n")); err != nil {
+			t.Fatalf("failed to write request: %v", err)
+		}
+		got, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("failed to read response: %v", err)
+		}
+		if got = strings.TrimSpace(got); !strings.HasPrefix(got, step.want) {
+			t.Errorf("%s: expected %q, got %q", step.line, step.want, got)
+		}
+	}
+}
+
+func TestReadFailedResponse(t *testing.T) {
+	if got := readFailedResponse(protocolV1); got != "ERROR: Failed to read request\n" {
+		t.Errorf("expected legacy error, got %q", got)
+	}
+	if got := readFailedResponse(protocolV2); got != `{"status":"error","code":"read_failed","error":"failed to read request"}`+"\n" {
+		t.Errorf("expected JSON error, got %q", got)
+	}
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultWorkers is the number of notifications delivered concurrently.
+	defaultWorkers = 4
+	// defaultQueueDepth is the number of notifications that may wait for a
+	// free worker before new requests are rejected.
+	defaultQueueDepth = 256
+)
+
+var (
+	// errQueueFull is returned when every queue slot is taken.
+	errQueueFull = errors.New("queue full")
+	// errQueueClosed is returned for submissions after shutdown began.
+	errQueueClosed = errors.New("server shutting down")
+	// errExpired is returned for notifications whose expires_at passed
+	// before they could be delivered.
+	errExpired = errors.New("notification expired")
+)
+
+// deliveryJob is a notification waiting for a worker. If done is non-nil
+// the delivery result is sent on it.
+type deliveryJob struct {
+	id   string
+	req  NotificationRequest
+	done chan error
+}
+
+// deliveryQueue runs notifications through a fixed pool of workers so a
+// burst of requests cannot start an unbounded number of backend processes.
+type deliveryQueue struct {
+	jobs    chan deliveryJob
+	workers int
+	deliver func(id string, req NotificationRequest) error
+
+	mu     sync.RWMutex
+	closed bool
+	start  sync.Once
+	wg     sync.WaitGroup
+}
+
+func newDeliveryQueue(workers, depth int, deliver func(id string, req NotificationRequest) error) *deliveryQueue {
+	if workers < 1 {
+		workers = 1
+	}
+	if depth < 0 {
+		depth = 0
+	}
+	return &deliveryQueue{
+		jobs:    make(chan deliveryJob, depth),
+		workers: workers,
+		deliver: deliver,
+	}
+}
+
+// startWorkers starts the worker pool on first use.
+func (q *deliveryQueue) startWorkers() {
+	q.start.Do(func() {
+		for i := 0; i < q.workers; i++ {
+			q.wg.Add(1)
+			go q.work()
+		}
+	})
+}
+
+// submit enqueues job without blocking.
+func (q *deliveryQueue) submit(job deliveryJob) error {
+	q.startWorkers()
+
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	if q.closed {
+		return errQueueClosed
+	}
+
+	select {
+	case q.jobs <- job:
+		return nil
+	default:
+		return errQueueFull
+	}
+}
+
+// submitWait enqueues job, waiting for a free slot if the queue is full.
+func (q *deliveryQueue) submitWait(job deliveryJob) error {
+	q.startWorkers()
+
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	if q.closed {
+		return errQueueClosed
+	}
+	q.jobs <- job
+	return nil
+}
+
+// len returns the number of notifications waiting for a worker.
+func (q *deliveryQueue) len() int {
+	return len(q.jobs)
+}
+
+// close stops accepting jobs and waits for queued ones to be delivered.
+func (q *deliveryQueue) close() {
+	q.mu.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.jobs)
+	}
+	q.mu.Unlock()
+	q.wg.Wait()
+}
+
+func (q *deliveryQueue) work() {
+	defer q.wg.Done()
+	for job := range q.jobs {
+		err := q.deliver(job.id, job.req)
+		if job.done != nil {
+			job.done <- err
+		}
+	}
+}
+
+// WithQueue sets the number of delivery workers and how many notifications
+// may wait for one.
+func WithQueue(workers, depth int) Option {
+	return func(s *Server) {
+		s.workers = workers
+		s.queueDepth = depth
+	}
+}
+
+// WithAsync acknowledges notifications with QUEUED as soon as they are
+// queued, rather than waiting for delivery.
+func WithAsync() Option {
+	return func(s *Server) {
+		s.async = true
+	}
+}
+
+// enqueue queues req, received from o, for delivery unless it repeats a
+// recent notification or is scheduled for later. Unless the server is
+// asynchronous it waits for the result.
+func (s *Server) enqueue(req NotificationRequest, o origin) (result, error) {
+	id := newID()
+	if original, dup := s.isDuplicate(req, id); dup {
+		s.recordHistory(id, req, o, historyDuplicate)
+		return result{status: statusDuplicate, id: original}, nil
+	}
+
+	var res result
+	var err error
+	req.resolveSchedule(time.Now())
+	s.recordHistory(id, req, o, historyPending)
+	if at := req.scheduledTime(); at.After(time.Now()) {
+		res, err = s.schedule(id, req, at)
+	} else {
+		res, err = s.dispatch(id, req, !s.async, false)
+	}
+	if err != nil {
+		s.forgetDuplicate(req, id)
+		s.updateHistory(id, historyFailed, err)
+	}
+	return res, err
+}
+
+// dispatch delivers a notification that is due, unless it arrives during
+// quiet hours or is held back to be coalesced with others. If wait is set
+// it waits for the result. acked is set for notifications the client was
+// already told about, such as scheduled ones falling due: they are handed
+// off rather than refused when the queue is full.
+func (s *Server) dispatch(id string, req NotificationRequest, wait, acked bool) (result, error) {
+	if res, handled, err := s.applyQuietHours(id, &req); handled {
+		return res, err
+	}
+	if s.coalescer != nil && req.Group != "" {
+		return s.coalesce(id, req, wait, acked)
+	}
+	if acked {
+		if err := s.handOff(deliveryJob{id: id, req: req}, nil); err != nil {
+			return result{}, err
+		}
+		return result{status: statusQueued, id: id}, nil
+	}
+	return s.submit(id, req, wait)
+}
+
+// submit spools req and hands it to the delivery queue under id. If wait is
+// set it waits for the result.
+func (s *Server) submit(id string, req NotificationRequest, wait bool) (result, error) {
+	job := deliveryJob{id: id, req: req}
+	if wait {
+		job.done = make(chan error, 1)
+	}
+
+	if err := s.accept(job); err != nil {
+		return result{}, err
+	}
+	if !wait {
+		return result{status: statusQueued, id: job.id}, nil
+	}
+	if err := <-job.done; errors.Is(err, errExpired) {
+		return result{status: statusExpired, id: job.id}, nil
+	} else if err != nil {
+		return result{}, err
+	}
+	return result{status: statusOK, id: job.id}, nil
+}
+
+// accept spools job and hands it to the delivery queue without waiting for
+// it to be delivered.
+func (s *Server) accept(job deliveryJob) error {
+	// Persist before acknowledging so the notification survives a restart
+	if s.spool != nil {
+		if err := s.spool.add(job.id, job.req); err != nil {
+			return err
+		}
+	}
+
+	if err := s.queue.submit(job); err != nil {
+		if s.spool != nil {
+			s.spool.remove(job.id)
+		}
+		return err
+	}
+	return nil
+}
+
+// handOff queues job for a notification the client was already told
+// about, waiting for a free slot if the queue is full. If job is a digest
+// of members, it is spooled in their place first. Whatever stands for the
+// notification in the spool is kept if it cannot be queued, so it is
+// replayed on the next start.
+func (s *Server) handOff(job deliveryJob, members []pendingNotification) error {
+	if s.spool != nil && len(members) > 1 {
+		if err := s.spool.add(job.id, job.req); err != nil {
+			return err
+		}
+		for _, m := range members {
+			s.spool.remove(m.id)
+		}
+	}
+	return s.queue.submitWait(job)
+}
+
+// dropExpired discards a notification whose expires_at has passed.
+func (s *Server) dropExpired(id string, req NotificationRequest) {
+	s.log.Info("Dropped expired notification", "request_id", id, "expires_at", req.ExpiresAt)
+	if s.spool != nil {
+		s.spool.remove(id)
+	}
+	s.forgetDuplicate(req, id)
+	s.updateHistory(id, historyExpired, nil)
+}
+
+// newID returns a random identifier for a notification.
+func newID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic("crypto/rand failed: " + err.Error())
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// blockingNotifier holds every delivery until release is closed, tracking
+// how many deliveries run at once.
+type blockingNotifier struct {
+	fakeNotifier
+	started chan struct{}
+	release chan struct{}
+	active  atomic.Int32
+	peak    atomic.Int32
+}
+
+func newBlockingNotifier() *blockingNotifier {
+	return &blockingNotifier{
+		started: make(chan struct{}, 100),
+		release: make(chan struct{}),
+	}
+}
+
+func (b *blockingNotifier) Notify(req NotificationRequest) error {
+	n := b.active.Add(1)
+	defer b.active.Add(-1)
+	for {
+		peak := b.peak.Load()
+		if n <= peak || b.peak.CompareAndSwap(peak, n) {
+			break
+		}
+	}
+	b.started <- struct{}{}
+	<-b.release
+	return b.fakeNotifier.Notify(req)
+}
+
+func TestQueueBoundsConcurrency(t *testing.T) {
+	backend := newBlockingNotifier()
+	s := NewServer("localhost", 0, false, WithNotifier(backend), WithQueue(2, 20))
+	t.Cleanup(s.Stop)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := s.perform(NotificationRequest{Title: "T", Message: "M"}, origin{}); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		}()
+	}
+
+	// Wait for both workers to be busy, then let everything through
+	<-backend.started
+	<-backend.started
+	close(backend.release)
+	wg.Wait()
+
+	if peak := backend.peak.Load(); peak > 2 {
+		t.Errorf("expected at most 2 concurrent deliveries, got %d", peak)
+	}
+	if got := len(backend.requests()); got != 10 {
+		t.Errorf("expected 10 delivered notifications, got %d", got)
+	}
+}
+
+func TestQueueFull(t *testing.T) {
+	backend := newBlockingNotifier()
+	s := NewServer("localhost", 0, false, WithNotifier(backend), WithQueue(1, 1), WithAsync())
+	t.Cleanup(func() {
+		close(backend.release)
+		s.Stop()
+	})
+
+	req := NotificationRequest{Title: "T", Message: "M"}
+
+	// The first request occupies the only worker
+	if _, err := s.perform(req, origin{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	<-backend.started
+
+	// The second fills the only queue slot
+	if _, err := s.perform(req, origin{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got := roundTrip(t, s, `{"title":"T","message":"M"}`); got != "ERROR: queue full" {
+		t.Errorf("expected queue full error, got %q", got)
+	}
+}
+
+func TestAsyncDelivery(t *testing.T) {
+	fake := &fakeNotifier{}
+	s := NewServer("localhost", 0, false, WithNotifier(fake), WithAsync())
+
+	got := roundTrip(t, s, `{"title":"Async","message":"Hello"}`)
+	id, ok := strings.CutPrefix(got, "QUEUED ")
+	if !ok || len(id) != 16 {
+		t.Fatalf("expected QUEUED <id>, got %q", got)
+	}
+
+	// Stop drains the queue before returning
+	s.Stop()
+	reqs := fake.requests()
+	if len(reqs) != 1 || reqs[0].Title != "Async" {
+		t.Errorf("expected queued notification to be delivered, got %+v", reqs)
+	}
+
+	if _, err := s.perform(NotificationRequest{Title: "T", Message: "M"}, origin{}); !errors.Is(err, errQueueClosed) {
+		t.Errorf("expected errQueueClosed after stop, got %v", err)
+	}
+}
+
+func TestSyncDeliveryReportsBackendError(t *testing.T) {
+	fake := &fakeNotifier{err: errors.New("backend down")}
+	s := NewServer("localhost", 0, false, WithNotifier(fake), WithRetry(1, 0, 0))
+	t.Cleanup(s.Stop)
+
+	if got := roundTrip(t, s, `{"title":"T","message":"M"}`); got != "ERROR: backend down" {
+		t.Errorf("expected backend error, got %q", got)
+	}
+}
+
+func TestExpiredNotificationIsNotDelivered(t *testing.T) {
+	fake := &fakeNotifier{}
+	s := NewServer("localhost", 0, false, WithNotifier(fake))
+	t.Cleanup(s.Stop)
+
+	past := time.Now().Add(-time.Minute).Format(time.RFC3339)
+	if got := roundTrip(t, s, fmt.Sprintf(`{"title":"Deploy","message":"Started","expires_at":%q}`, past)); got != "EXPIRED" {
+		t.Errorf("expected EXPIRED, got %q", got)
+	}
+	if n := len(fake.requests()); n != 0 {
+		t.Errorf("expected nothing delivered, got %d", n)
+	}
+}
+
+func TestNotificationExpiresWhileQueued(t *testing.T) {
+	backend := newBlockingNotifier()
+	s := NewServer("localhost", 0, false, WithNotifier(backend), WithQueue(1, 1))
+	t.Cleanup(s.Stop)
+
+	// Occupy the only worker so the next notification waits in the queue
+	go s.perform(NotificationRequest{Title: "First", Message: "M"}, origin{})
+	<-backend.started
+
+	done := make(chan result, 1)
+	go func() {
+		res, err := s.perform(NotificationRequest{Title: "Stale", Message: "M", TTL: "50ms"}, origin{})
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		done <- res
+	}()
+	time.Sleep(100 * time.Millisecond)
+	close(backend.release)
+
+	if res := <-done; res.status != statusExpired {
+		t.Errorf("expected status %q, got %q", statusExpired, res.status)
+	}
+	if reqs := backend.requests(); len(reqs) != 1 || reqs[0].Title != "First" {
+		t.Errorf("expected only the first notification to be delivered, got %+v", reqs)
+	}
+}
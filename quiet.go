@@ -0,0 +1,304 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// Quiet hours modes: what happens to notifications during quiet hours.
+const (
+	quietHold   = "hold"
+	quietDrop   = "drop"
+	quietSilent = "silent"
+)
+
+const (
+	// quietDigestTitle titles the digest of notifications held during quiet
+	// hours when they do not share a title.
+	quietDigestTitle = "Quiet hours"
+	// quietDigestTemplate renders the message of that digest.
+	quietDigestTemplate = "{{.Count}} notifications during quiet hours: {{.Summary}}"
+)
+
+// weekdayNames maps the abbreviations accepted in schedules to weekdays.
+var weekdayNames = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// quietWindow is a daily time range on selected weekdays. A range whose end
+// is not after its start runs past midnight into the next day.
+type quietWindow struct {
+	days  [7]bool
+	start int // minutes after midnight
+	end   int
+}
+
+// parseQuietWindow parses a window such as "22:00-07:00", "Mon-Fri
+// 18:30-08:00" or "Sat,Sun 00:00-00:00" (all day). Without days the window
+// applies every day.
+func parseQuietWindow(spec string) (quietWindow, error) {
+	var w quietWindow
+	fields := strings.Fields(spec)
+	var days, times string
+	switch len(fields) {
+	case 1:
+		days, times = "", fields[0]
+	case 2:
+		days, times = fields[0], fields[1]
+	default:
+		return w, fmt.Errorf("invalid quiet hours %q: expected [days] HH:MM-HH:MM", spec)
+	}
+
+	if days == "" {
+		for i := range w.days {
+			w.days[i] = true
+		}
+	}
+	for _, part := range strings.Split(days, ",") {
+		if part == "" {
+			continue
+		}
+		from, to, isRange := strings.Cut(strings.ToLower(part), "-")
+		first, ok1 := weekdayNames[from]
+		last, ok2 := weekdayNames[to]
+		if !isRange {
+			last, ok2 = first, ok1
+		}
+		if !ok1 || !ok2 {
+			return w, fmt.Errorf("invalid quiet hours %q: unknown day %q", spec, part)
+		}
+		for d := first; ; d = (d + 1) % 7 {
+			w.days[d] = true
+			if d == last {
+				break
+			}
+		}
+	}
+
+	start, end, ok := strings.Cut(times, "-")
+	var err error
+	if !ok {
+		return w, fmt.Errorf("invalid quiet hours %q: expected HH:MM-HH:MM", spec)
+	}
+	if w.start, err = parseClock(start); err != nil {
+		return w, fmt.Errorf("invalid quiet hours %q: %w", spec, err)
+	}
+	if w.end, err = parseClock(end); err != nil {
+		return w, fmt.Errorf("invalid quiet hours %q: %w", spec, err)
+	}
+	return w, nil
+}
+
+// parseClock parses HH:MM into minutes after midnight.
+func parseClock(s string) (int, error) {
+	h, m, ok := strings.Cut(s, ":")
+	hour, err1 := strconv.Atoi(h)
+	minute, err2 := strconv.Atoi(m)
+	if !ok || err1 != nil || err2 != nil || hour < 0 || hour > 23 || minute < 0 || minute > 59 {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+	return hour*60 + minute, nil
+}
+
+// quietHours mutes notifications on a weekly schedule, in the local time
+// zone. Held notifications are delivered as a digest when quiet hours end.
+type quietHours struct {
+	windows []quietWindow
+	mode    string
+	tmpl    *template.Template
+	now     func() time.Time
+	flush   func(held []pendingNotification)
+
+	mu    sync.Mutex
+	held  []pendingNotification
+	timer *time.Timer
+}
+
+// newQuietHours parses the schedule in specs, one window each, and returns
+// quiet hours that handle notifications according to mode.
+func newQuietHours(specs []string, mode string) (*quietHours, error) {
+	switch mode {
+	case quietHold, quietDrop, quietSilent:
+	default:
+		return nil, fmt.Errorf("invalid quiet hours mode %q (use hold, drop or silent)", mode)
+	}
+	q := &quietHours{
+		mode: mode,
+		tmpl: template.Must(template.New("quiet").Parse(quietDigestTemplate)),
+		now:  time.Now,
+	}
+	for _, spec := range specs {
+		w, err := parseQuietWindow(spec)
+		if err != nil {
+			return nil, err
+		}
+		q.windows = append(q.windows, w)
+	}
+	return q, nil
+}
+
+// WithQuietHours holds, drops or silences notifications during q's
+// schedule. Urgent notifications are always delivered normally.
+func WithQuietHours(q *quietHours) Option {
+	return func(s *Server) {
+		s.quiet = q
+	}
+}
+
+// until reports whether t falls in quiet hours and, if so, when they end.
+// Adjoining or overlapping windows count as one.
+func (q *quietHours) until(t time.Time) (time.Time, bool) {
+	end, ok := q.windowEnd(t)
+	if !ok {
+		return time.Time{}, false
+	}
+	for i := 0; i < 8; i++ {
+		next, ok := q.windowEnd(end)
+		if !ok || !next.After(end) {
+			break
+		}
+		end = next
+	}
+	return end, true
+}
+
+// windowEnd returns the latest end of the windows containing t.
+func (q *quietHours) windowEnd(t time.Time) (time.Time, bool) {
+	var end time.Time
+	found := false
+	for _, w := range q.windows {
+		// A window containing t started today or, past midnight, yesterday
+		for back := 0; back <= 1; back++ {
+			day := time.Date(t.Year(), t.Month(), t.Day()-back, 0, 0, 0, 0, t.Location())
+			if !w.days[day.Weekday()] {
+				continue
+			}
+			start := time.Date(day.Year(), day.Month(), day.Day(), w.start/60, w.start%60, 0, 0, t.Location())
+			endDay := day.Day()
+			if w.end <= w.start {
+				endDay++
+			}
+			stop := time.Date(day.Year(), day.Month(), endDay, w.end/60, w.end%60, 0, 0, t.Location())
+			if !t.Before(start) && t.Before(stop) {
+				found = true
+				if stop.After(end) {
+					end = stop
+				}
+			}
+		}
+	}
+	return end, found
+}
+
+// hold keeps n until quiet hours end at until.
+func (q *quietHours) hold(n pendingNotification, until time.Time) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.held = append(q.held, n)
+	if q.timer == nil {
+		q.timer = time.AfterFunc(until.Sub(q.now()), q.release)
+	}
+}
+
+// release hands over the held notifications once quiet hours are over.
+func (q *quietHours) release() {
+	q.mu.Lock()
+	if until, quiet := q.until(q.now()); quiet {
+		q.timer = time.AfterFunc(until.Sub(q.now()), q.release)
+		q.mu.Unlock()
+		return
+	}
+	held := q.held
+	q.held = nil
+	q.timer = nil
+	q.mu.Unlock()
+
+	if len(held) > 0 {
+		q.flush(held)
+	}
+}
+
+// close stops waiting for quiet hours to end and returns how many
+// notifications were still held.
+func (q *quietHours) close() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.timer != nil {
+		q.timer.Stop()
+		q.timer = nil
+	}
+	return len(q.held)
+}
+
+// applyQuietHours handles req if it arrives during quiet hours: it is held
+// or dropped, reported by handled, or stripped of its sound. Urgent
+// notifications are left alone.
+func (s *Server) applyQuietHours(id string, req *NotificationRequest) (res result, handled bool, err error) {
+	if s.quiet == nil || req.Urgent {
+		return result{}, false, nil
+	}
+	until, quiet := s.quiet.until(s.quiet.now())
+	if !quiet {
+		return result{}, false, nil
+	}
+
+	switch s.quiet.mode {
+	case quietSilent:
+		req.Sound = ""
+		return result{}, false, nil
+	case quietDrop:
+		s.log.Debug("Dropped notification during quiet hours", "request_id", id)
+		// A scheduled notification falling due was spooled
+		if s.spool != nil {
+			s.spool.remove(id)
+		}
+		s.updateHistory(id, historyDropped, nil)
+		return result{status: statusDropped, id: id}, true, nil
+	default:
+		if s.spool != nil {
+			if err := s.spool.add(id, *req); err != nil {
+				return result{}, true, err
+			}
+		}
+		s.quiet.hold(pendingNotification{id: id, req: *req}, until)
+		s.log.Debug("Holding notification until quiet hours end", "request_id", id, "until", until)
+		return result{status: statusQueued, id: id}, true, nil
+	}
+}
+
+// releaseHeld delivers the notifications held during quiet hours, combined
+// into a digest if there is more than one.
+func (s *Server) releaseHeld(held []pendingNotification) {
+	if held = s.unexpired(held); len(held) == 0 {
+		s.log.Info("Quiet hours over, every held notification expired")
+		return
+	}
+	s.log.Info("Quiet hours over, delivering held notifications", "count", len(held))
+
+	id, req := held[0].id, held[0].req
+	if len(held) > 1 {
+		digest, err := buildDigest(s.quiet.tmpl, quietDigestTitle, held)
+		if err != nil {
+			s.log.Error("Error building quiet hours digest", "error", err)
+			return
+		}
+		id, req = newID(), digest
+		s.recordDigest(id, req, held)
+	}
+
+	// They were acknowledged as QUEUED, so they wait for room in the queue
+	if err := s.handOff(deliveryJob{id: id, req: req}, held); err != nil {
+		s.log.Error("Error queueing notifications held during quiet hours", "request_id", id, "error", err)
+		s.updateHistory(id, historyFailed, err)
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseQuietWindow(t *testing.T) {
+	tests := []struct {
+		spec    string
+		days    string // weekdays included, Sunday first
+		start   int
+		end     int
+		wantErr bool
+	}{
+		{spec: "22:00-07:00", days: "SMTWTFS", start: 22 * 60, end: 7 * 60},
+		{spec: "Mon-Fri 18:30-08:00", days: ".MTWTF.", start: 18*60 + 30, end: 8 * 60},
+		{spec: "sat,sun 00:00-00:00", days: "S.....S", start: 0, end: 0},
+		{spec: "Fri-Mon 12:00-13:00", days: "SM...FS", start: 12 * 60, end: 13 * 60},
+		{spec: "Funday 10:00-11:00", wantErr: true},
+		{spec: "Mon 25:00-07:00", wantErr: true},
+		{spec: "Mon 22:00", wantErr: true},
+		{spec: "Mon Tue 22:00-07:00", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			w, err := parseQuietWindow(tt.spec)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("expected error, got %+v", w)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var days strings.Builder
+			for d, on := range w.days {
+				if on {
+					days.WriteByte("SMTWTFS"[d])
+				} else {
+					days.WriteByte('.')
+				}
+			}
+			if days.String() != tt.days || w.start != tt.start || w.end != tt.end {
+				t.Errorf("expected %s %d-%d, got %s %d-%d", tt.days, tt.start, tt.end, days.String(), w.start, w.end)
+			}
+		})
+	}
+}
+
+func TestQuietHoursUntil(t *testing.T) {
+	q, err := newQuietHours([]string{"Mon-Fri 22:00-07:00", "Sat,Sun 00:00-00:00"}, quietHold)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// 2026-10-16 is a Friday
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2026, 10, day, hour, minute, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		name  string
+		now   time.Time
+		quiet bool
+		until time.Time
+	}{
+		{"weekday afternoon", at(14, 12, 0), false, time.Time{}},
+		{"weekday night", at(14, 23, 0), true, at(15, 7, 0)},
+		{"early morning", at(15, 6, 59), true, at(15, 7, 0)},
+		{"end of window", at(15, 7, 0), false, time.Time{}},
+		{"friday night runs into the weekend", at(16, 23, 0), true, at(19, 0, 0)},
+		{"monday morning after the weekend", at(19, 3, 0), false, time.Time{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			until, quiet := q.until(tt.now)
+			if quiet != tt.quiet || !until.Equal(tt.until) {
+				t.Errorf("expected %v until %v, got %v until %v", tt.quiet, tt.until, quiet, until)
+			}
+		})
+	}
+}
+
+// quietServer returns a server whose quiet hours run from 22:00 to 07:00
+// every day, with the clock set to 23:00.
+func quietServer(t *testing.T, mode string, fake *fakeNotifier) (*Server, *time.Time) {
+	t.Helper()
+	q, err := newQuietHours([]string{"22:00-07:00"}, mode)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	now := time.Date(2026, 10, 16, 23, 0, 0, 0, time.UTC)
+	q.now = func() time.Time { return now }
+
+	s := NewServer("localhost", 0, false, WithNotifier(fake), WithQuietHours(q))
+	t.Cleanup(s.Stop)
+	return s, &now
+}
+
+func TestQuietHoursDrop(t *testing.T) {
+	fake := &fakeNotifier{}
+	s, _ := quietServer(t, quietDrop, fake)
+
+	if got := roundTrip(t, s, `{"title":"Build","message":"Done"}`); got != "OK dropped" {
+		t.Errorf("expected OK dropped, got %q", got)
+	}
+	if got := roundTrip(t, s, `{"title":"Pager","message":"Prod down","urgent":true}`); got != "OK" {
+		t.Errorf("expected urgent notification to be delivered, got %q", got)
+	}
+	if reqs := fake.requests(); len(reqs) != 1 || reqs[0].Title != "Pager" {
+		t.Errorf("expected only the urgent notification, got %+v", reqs)
+	}
+}
+
+func TestQuietHoursSilent(t *testing.T) {
+	fake := &fakeNotifier{}
+	s, _ := quietServer(t, quietSilent, fake)
+
+	if got := roundTrip(t, s, `{"title":"Build","message":"Done","sound":"Hero"}`); got != "OK" {
+		t.Errorf("expected OK, got %q", got)
+	}
+	if reqs := fake.requests(); len(reqs) != 1 || reqs[0].Sound != "" {
+		t.Errorf("expected notification without sound, got %+v", reqs)
+	}
+}
+
+func TestQuietHoursHold(t *testing.T) {
+	fake := &fakeNotifier{}
+	s, now := quietServer(t, quietHold, fake)
+
+	for _, line := range []string{
+		`{"title":"Tests","message":"passed"}`,
+		`{"title":"Tests","message":"failed"}`,
+	} {
+		if got := roundTrip(t, s, line); !strings.HasPrefix(got, "QUEUED ") {
+			t.Fatalf("expected held notification to be QUEUED, got %q", got)
+		}
+	}
+	if got := len(fake.requests()); got != 0 {
+		t.Fatalf("expected nothing delivered during quiet hours, got %d", got)
+	}
+
+	// Nothing is released while it is still quiet
+	s.quiet.release()
+	if got := len(fake.requests()); got != 0 {
+		t.Fatalf("expected nothing delivered during quiet hours, got %d", got)
+	}
+
+	*now = now.Add(8 * time.Hour)
+	s.quiet.release()
+	s.Stop()
+
+	reqs := fake.requests()
+	if len(reqs) != 1 {
+		t.Fatalf("expected one digest, got %+v", reqs)
+	}
+	if reqs[0].Title != "Tests" || reqs[0].Message != "2 notifications during quiet hours: 1 passed, 1 failed" {
+		t.Errorf("unexpected digest %+v", reqs[0])
+	}
+}
+
+func TestQuietHoursHoldWaitsForFullQueue(t *testing.T) {
+	q, err := newQuietHours([]string{"22:00-07:00"}, quietHold)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	now := time.Date(2026, 10, 16, 23, 0, 0, 0, time.UTC)
+	q.now = func() time.Time { return now }
+	sp := testSpool(t)
+	backend := newBlockingNotifier()
+	s := NewServer("localhost", 0, false, WithNotifier(backend), WithQuietHours(q), WithSpool(sp), WithQueue(1, 1), WithAsync())
+	released := false
+	t.Cleanup(func() {
+		if !released {
+			close(backend.release)
+		}
+		s.Stop()
+	})
+
+	got := roundTrip(t, s, `{"title":"Build","message":"Done"}`)
+	id, ok := strings.CutPrefix(got, "QUEUED ")
+	if !ok {
+		t.Fatalf("expected held notification to be QUEUED, got %q", got)
+	}
+
+	// Urgent notifications occupy the only worker and the only queue slot
+	if got := roundTrip(t, s, `{"title":"Pager","message":"1","urgent":true}`); !strings.HasPrefix(got, "QUEUED ") {
+		t.Fatalf("expected QUEUED, got %q", got)
+	}
+	<-backend.started
+	if got := roundTrip(t, s, `{"title":"Pager","message":"2","urgent":true}`); !strings.HasPrefix(got, "QUEUED ") {
+		t.Fatalf("expected QUEUED, got %q", got)
+	}
+
+	// Released into a full queue, the held notification waits in the spool
+	now = now.Add(8 * time.Hour)
+	go q.release()
+	time.Sleep(100 * time.Millisecond)
+	if _, err := os.Stat(sp.path(id)); err != nil {
+		t.Fatalf("expected the held notification to stay spooled, got %v", err)
+	}
+
+	close(backend.release)
+	released = true
+	deadline := time.Now().Add(2 * time.Second)
+	for len(backend.requests()) < 3 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if reqs := backend.requests(); len(reqs) != 3 || reqs[2].Title != "Build" {
+		t.Errorf("expected the held notification to be delivered after the queue drained, got %+v", reqs)
+	}
+}
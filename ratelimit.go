@@ -0,0 +1,244 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxIdleBuckets is how many client buckets are kept before full ones are
+// swept away.
+const maxIdleBuckets = 1024
+
+// errRateLimited is returned for requests from a client that exceeded its
+// rate limit, wrapped in a rateLimitError that says when to retry.
+var errRateLimited = errors.New("rate limited")
+
+// rateLimitError is errRateLimited along with when to retry.
+type rateLimitError struct {
+	retryAfter time.Duration
+}
+
+func (e *rateLimitError) Error() string {
+	return fmt.Sprintf("rate limited, retry after %d seconds", e.seconds())
+}
+
+func (e *rateLimitError) Unwrap() error { return errRateLimited }
+
+// seconds returns the retry delay rounded up to whole seconds.
+func (e *rateLimitError) seconds() int {
+	return max(1, int(math.Ceil(e.retryAfter.Seconds())))
+}
+
+// rateLimit allows rate requests per second on average, with bursts of up
+// to burst requests. A zero rate means unlimited.
+type rateLimit struct {
+	rate  float64
+	burst int
+}
+
+// parseRateLimit parses a rate such as "5", "5/s", "30/m" or "100/h",
+// optionally followed by ":burst". Without a burst, one second's worth of
+// requests (and at least one) may be sent at once.
+func parseRateLimit(spec string) (rateLimit, error) {
+	spec, burstSpec, hasBurst := strings.Cut(spec, ":")
+	count, unit, _ := strings.Cut(spec, "/")
+
+	n, err := strconv.ParseFloat(count, 64)
+	if err != nil || n < 0 {
+		return rateLimit{}, fmt.Errorf("invalid rate %q", spec)
+	}
+	switch unit {
+	case "", "s":
+	case "m":
+		n /= 60
+	case "h":
+		n /= 3600
+	default:
+		return rateLimit{}, fmt.Errorf("invalid rate unit %q in %q", unit, spec)
+	}
+
+	l := rateLimit{rate: n, burst: max(1, int(math.Ceil(n)))}
+	if hasBurst {
+		burst, err := strconv.Atoi(burstSpec)
+		if err != nil || burst < 1 {
+			return rateLimit{}, fmt.Errorf("invalid burst %q", burstSpec)
+		}
+		l.burst = burst
+	}
+	return l, nil
+}
+
+// parseClientRateLimit parses a "client=rate[:burst]" override, where
+// client is a token name or an IP address.
+func parseClientRateLimit(spec string) (string, rateLimit, error) {
+	client, limit, ok := strings.Cut(spec, "=")
+	if !ok || client == "" {
+		return "", rateLimit{}, fmt.Errorf("invalid client rate limit %q: expected client=rate[:burst]", spec)
+	}
+	l, err := parseRateLimit(limit)
+	if err != nil {
+		return "", rateLimit{}, fmt.Errorf("invalid client rate limit %q: %w", spec, err)
+	}
+	return client, l, nil
+}
+
+// buildRateLimiter combines the global limit and per-client overrides. It
+// returns nil when no limit is configured.
+func buildRateLimiter(global string, clients []string) (*rateLimiter, error) {
+	limit, err := parseRateLimit(global)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --rate-limit: %w", err)
+	}
+	overrides := make(map[string]rateLimit)
+	for _, spec := range clients {
+		client, l, err := parseClientRateLimit(spec)
+		if err != nil {
+			return nil, err
+		}
+		overrides[client] = l
+	}
+	if limit.rate <= 0 && len(overrides) == 0 {
+		return nil, nil
+	}
+	return newRateLimiter(limit, overrides), nil
+}
+
+// tokenBucket tracks one client's remaining allowance.
+type tokenBucket struct {
+	limit    rateLimit
+	tokens   float64
+	last     time.Time
+	rejected int
+}
+
+// refill adds the tokens earned since the bucket was last used.
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens = math.Min(float64(b.limit.burst), b.tokens+now.Sub(b.last).Seconds()*b.limit.rate)
+	b.last = now
+}
+
+// rateLimiter applies token-bucket limits per client. Clients named in
+// clients get their own limit instead of the global one.
+type rateLimiter struct {
+	global  rateLimit
+	clients map[string]rateLimit
+	now     func() time.Time
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+func newRateLimiter(global rateLimit, clients map[string]rateLimit) *rateLimiter {
+	return &rateLimiter{
+		global:  global,
+		clients: clients,
+		now:     time.Now,
+		buckets: make(map[string]*tokenBucket),
+	}
+}
+
+// WithRateLimit limits how often each client may send requests.
+func WithRateLimit(l *rateLimiter) Option {
+	return func(s *Server) {
+		s.limiter = l
+	}
+}
+
+// limitFor returns the limit for a client identified by token name (if it
+// authenticated) and IP address.
+func (l *rateLimiter) limitFor(tokenName, ip string) rateLimit {
+	if limit, ok := l.clients[tokenName]; ok && tokenName != "" {
+		return limit
+	}
+	if limit, ok := l.clients[ip]; ok {
+		return limit
+	}
+	return l.global
+}
+
+// allow takes one request from the client's allowance. Authenticated
+// clients are limited per token, others per IP address.
+func (l *rateLimiter) allow(tokenName, ip string) error {
+	key := "ip " + ip
+	if tokenName != "" {
+		key = "token " + tokenName
+	}
+	limit := l.limitFor(tokenName, ip)
+	if limit.rate <= 0 {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	b, ok := l.buckets[key]
+	if !ok {
+		if len(l.buckets) >= maxIdleBuckets {
+			l.sweepLocked(now)
+		}
+		b = &tokenBucket{limit: limit, tokens: float64(limit.burst), last: now}
+		l.buckets[key] = b
+	}
+	b.refill(now)
+
+	if b.tokens < 1 {
+		if b.rejected == 0 {
+			slog.Warn("Rate limiting client", "client", key, "rate", limit.rate, "burst", limit.burst)
+		}
+		b.rejected++
+		wait := time.Duration((1 - b.tokens) / limit.rate * float64(time.Second))
+		return &rateLimitError{retryAfter: wait}
+	}
+	if b.rejected > 0 {
+		slog.Info("Rate limit lifted", "client", key, "rejected", b.rejected)
+		b.rejected = 0
+	}
+	b.tokens--
+	return nil
+}
+
+// sweepLocked forgets buckets that have refilled completely, since a new
+// bucket would behave the same.
+func (l *rateLimiter) sweepLocked(now time.Time) {
+	for key, b := range l.buckets {
+		b.refill(now)
+		if b.tokens >= float64(b.limit.burst) && b.rejected == 0 {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// checkRate applies the rate limit, if any, to a request from the client
+// with the given token name and IP address.
+func (s *Server) checkRate(tokenName, ip string) error {
+	limiter := s.currentLimiter()
+	if limiter == nil {
+		return nil
+	}
+	return limiter.allow(tokenName, ip)
+}
+
+// clientIP returns the address rate limits are keyed on for conn: the
+// remote IP, or "unix" for Unix domain socket clients.
+func clientIP(conn net.Conn) string {
+	if _, ok := conn.LocalAddr().(*net.UnixAddr); ok {
+		return "unix"
+	}
+	return hostOnly(conn.RemoteAddr().String())
+}
+
+// hostOnly strips the port from addr.
+func hostOnly(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseRateLimit(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    rateLimit
+		wantErr bool
+	}{
+		{spec: "5", want: rateLimit{rate: 5, burst: 5}},
+		{spec: "5/s:10", want: rateLimit{rate: 5, burst: 10}},
+		{spec: "30/m", want: rateLimit{rate: 0.5, burst: 1}},
+		{spec: "3600/h:20", want: rateLimit{rate: 1, burst: 20}},
+		{spec: "0", want: rateLimit{rate: 0, burst: 1}},
+		{spec: "fast", wantErr: true},
+		{spec: "5/d", wantErr: true},
+		{spec: "5:0", wantErr: true},
+		{spec: "-1", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			got, err := parseRateLimit(tt.spec)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("expected error, got %+v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("expected %+v, got %+v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestRateLimiterTokenBucket(t *testing.T) {
+	now := time.Now()
+	l := newRateLimiter(rateLimit{rate: 1, burst: 2}, map[string]rateLimit{
+		"ci-vm":    {rate: 10, burst: 10},
+		"10.0.0.9": {rate: 0},
+	})
+	l.now = func() time.Time { return now }
+
+	// The burst is available immediately, then requests are refused
+	for i := 0; i < 2; i++ {
+		if err := l.allow("", "10.0.0.1"); err != nil {
+			t.Fatalf("request %d: unexpected error: %v", i, err)
+		}
+	}
+	err := l.allow("", "10.0.0.1")
+	var limited *rateLimitError
+	if !errors.As(err, &limited) || !errors.Is(err, errRateLimited) {
+		t.Fatalf("expected rate limit error, got %v", err)
+	}
+	if limited.seconds() != 1 {
+		t.Errorf("expected retry after 1 second, got %d", limited.seconds())
+	}
+
+	// Other clients have their own buckets
+	if err := l.allow("", "10.0.0.2"); err != nil {
+		t.Errorf("expected other IP to be allowed, got %v", err)
+	}
+
+	// Tokens refill over time
+	now = now.Add(time.Second)
+	if err := l.allow("", "10.0.0.1"); err != nil {
+		t.Errorf("expected request after refill to be allowed, got %v", err)
+	}
+
+	// Authenticated clients are limited per token with their override
+	for i := 0; i < 10; i++ {
+		if err := l.allow("ci-vm", "10.0.0.1"); err != nil {
+			t.Fatalf("token request %d: unexpected error: %v", i, err)
+		}
+	}
+	if err := l.allow("ci-vm", "10.0.0.1"); !errors.Is(err, errRateLimited) {
+		t.Errorf("expected token to be limited after its burst, got %v", err)
+	}
+
+	// A zero override exempts a client
+	for i := 0; i < 5; i++ {
+		if err := l.allow("", "10.0.0.9"); err != nil {
+			t.Fatalf("exempt request %d: unexpected error: %v", i, err)
+		}
+	}
+}
+
+func TestRateLimitResponses(t *testing.T) {
+	limiter := newRateLimiter(rateLimit{rate: 0.1, burst: 1}, nil)
+	s := NewServer("localhost", 0, false, WithNotifier(&fakeNotifier{}), WithRateLimit(limiter))
+	t.Cleanup(s.Stop)
+
+	if got := roundTrip(t, s, `{"title":"T","message":"M"}`); got != "OK" {
+		t.Fatalf("expected OK, got %q", got)
+	}
+	if got := roundTrip(t, s, `{"title":"T","message":"M"}`); got != "ERROR: rate limited, retry after 10 seconds" {
+		t.Errorf("expected rate limited response, got %q", got)
+	}
+
+	send := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/v1/notify", strings.NewReader(`{"title":"T","message":"M"}`))
+		rec := httptest.NewRecorder()
+		s.httpHandler().ServeHTTP(rec, req)
+		return rec
+	}
+	if rec := send(); rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", rec.Code)
+	}
+	rec := send()
+	if rec.Code != http.StatusTooManyRequests {
+		t.Errorf("expected status 429, got %d", rec.Code)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "10" {
+		t.Errorf("expected Retry-After 10, got %q", got)
+	}
+}
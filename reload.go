@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+)
+
+// currentNotifier returns the backend that new deliveries use.
+func (s *Server) currentNotifier() Notifier {
+	s.cfgMu.RLock()
+	defer s.cfgMu.RUnlock()
+	return s.notifier
+}
+
+// currentTokens returns the auth tokens that new requests are checked
+// against.
+func (s *Server) currentTokens() *tokenSet {
+	s.cfgMu.RLock()
+	defer s.cfgMu.RUnlock()
+	return s.tokens
+}
+
+// currentLimiter returns the rate limiter applied to new requests, if any.
+func (s *Server) currentLimiter() *rateLimiter {
+	s.cfgMu.RLock()
+	defer s.cfgMu.RUnlock()
+	return s.limiter
+}
+
+// currentRetry returns the retry policy for new deliveries.
+func (s *Server) currentRetry() retryPolicy {
+	s.cfgMu.RLock()
+	defer s.cfgMu.RUnlock()
+	return s.retry
+}
+
+// currentTLS returns the certificates new connections are served with, or
+// nil when TLS is off.
+func (s *Server) currentTLS() *certReloader {
+	s.cfgMu.RLock()
+	defer s.cfgMu.RUnlock()
+	return s.tls
+}
+
+// Reconfigure applies new settings to the running server without dropping
+// connections. Auth tokens, the rate limiter, the notifier, the retry
+// policy and TLS certificates are swapped for the requests that follow,
+// and the TCP, Unix socket, HTTP and metrics listeners are re-bound if
+// their addresses changed, letting connections on the old ones finish.
+// Other options are ignored. If a new listener cannot be opened, nothing
+// is changed and the error is returned.
+func (s *Server) Reconfigure(host string, port int, opts ...Option) error {
+	s.reconfigMu.Lock()
+	defer s.reconfigMu.Unlock()
+
+	next := &Server{
+		host:       host,
+		port:       port,
+		socketMode: defaultSocketMode,
+		retry:      retryPolicy{attempts: defaultRetryAttempts, baseDelay: defaultRetryDelay, jitter: defaultRetryJitter},
+		log:        s.log,
+	}
+	for _, opt := range opts {
+		opt(next)
+	}
+	if next.notifier == nil {
+		next.notifier = s.currentNotifier()
+	}
+
+	tcpChanged := next.noTCP != s.noTCP || (!next.noTCP && (next.host != s.host || next.port != s.port))
+	unixChanged := next.socketPath != s.socketPath
+	httpChanged := next.httpPort != s.httpPort || (next.httpPort > 0 && next.host != s.host)
+	metricsChanged := next.metricsAddr != s.metricsAddr
+
+	// Open every new listener before touching the running ones
+	var opened []net.Listener
+	abort := func(err error) error {
+		for _, listener := range opened {
+			_ = listener.Close()
+		}
+		return err
+	}
+	listen := func(changed, enabled bool, open func() (net.Listener, error)) (net.Listener, error) {
+		if !changed || !enabled {
+			return nil, nil
+		}
+		listener, err := open()
+		if err != nil {
+			return nil, err
+		}
+		opened = append(opened, listener)
+		return listener, nil
+	}
+
+	tcpListener, err := listen(tcpChanged, !next.noTCP, func() (net.Listener, error) {
+		return listenTCP(next.host, next.port)
+	})
+	if err != nil {
+		return abort(err)
+	}
+	unixListener, err := listen(unixChanged, next.socketPath != "", next.listenUnix)
+	if err != nil {
+		return abort(err)
+	}
+	httpListener, err := listen(httpChanged, next.httpPort > 0, func() (net.Listener, error) {
+		return listenTCP(next.host, next.httpPort)
+	})
+	if err != nil {
+		return abort(err)
+	}
+	metricsListener, err := listen(metricsChanged, next.metricsAddr != "", func() (net.Listener, error) {
+		listener, err := net.Listen("tcp", next.metricsAddr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to listen on %s: %w", next.metricsAddr, err)
+		}
+		return listener, nil
+	})
+	if err != nil {
+		return abort(err)
+	}
+	if !unixChanged && next.socketPath != "" && (next.socketMode != s.socketMode || next.socketOwner != s.socketOwner) {
+		if err := setSocketAccess(next.socketPath, next.socketMode, next.socketOwner); err != nil {
+			return abort(err)
+		}
+	}
+
+	s.cfgMu.Lock()
+	s.tokens = next.tokens
+	s.limiter = next.limiter
+	s.notifier = next.notifier
+	s.retry = next.retry
+	s.tls = next.tls
+	s.cfgMu.Unlock()
+
+	s.mu.Lock()
+	s.host = next.host
+	s.port = next.port
+	s.noTCP = next.noTCP
+	s.httpPort = next.httpPort
+	s.socketPath = next.socketPath
+	s.socketMode = next.socketMode
+	s.socketOwner = next.socketOwner
+	s.metricsAddr = next.metricsAddr
+	s.mu.Unlock()
+
+	if tcpChanged {
+		s.closeListener(s.serveTCP(tcpListener))
+	}
+	if unixChanged {
+		s.closeListener(s.serveUnix(unixListener))
+	}
+	var old []*http.Server
+	if httpChanged {
+		old = append(old, s.serveHTTP(httpListener))
+	}
+	if metricsChanged {
+		old = append(old, s.serveMetrics(metricsListener))
+	}
+	s.shutdownHTTP(old...)
+	return nil
+}
+
+// closeListener closes a listener that has been replaced, if any.
+// Connections it accepted carry on.
+func (s *Server) closeListener(listener net.Listener) {
+	if listener == nil {
+		return
+	}
+	if err := listener.Close(); err != nil {
+		s.log.Debug("Error closing listener", "error", err)
+	}
+}
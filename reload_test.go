@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReconfigureSwapsSettings(t *testing.T) {
+	oldTokens, err := buildTokenSet([]string{"ci:one"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	newTokens, err := buildTokenSet([]string{"ci:two"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	fake := &fakeNotifier{}
+	s := NewServer("127.0.0.1", 0, false, WithNotifier(fake), WithTokens(oldTokens))
+	runServer(t, s)
+	addr := s.Addr().String()
+
+	// A connection opened before the reload stays open after it
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	reader := bufio.NewReader(conn)
+	send := func(line string) string {
+		t.Helper()
+		if err := conn.SetDeadline(time.Now().Add(5 * time.Second)); err != nil {
+			t.Fatalf("failed to set deadline: %v", err)
+		}
+		if _, err := conn.Write([]byte(line + "\n")); err != nil {
+			t.Fatalf("failed to write request: %v", err)
+		}
+		resp, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("failed to read response: %v", err)
+		}
+		return strings.TrimSpace(resp)
+	}
+	if got := send(`{"title":"T","message":"M","token":"one"}`); got != "OK" {
+		t.Fatalf("expected OK, got %q", got)
+	}
+
+	other := &fakeNotifier{}
+	if err := s.Reconfigure("127.0.0.1", 0, WithNotifier(other), WithTokens(newTokens), WithRetry(1, 0, 0)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got := send(`{"title":"T","message":"M","token":"one"}`); got != "ERROR: Unauthorized" {
+		t.Errorf("expected the old token to be rejected, got %q", got)
+	}
+	if got := send(`{"title":"T","message":"M","token":"two"}`); got != "OK" {
+		t.Errorf("expected the new token to be accepted, got %q", got)
+	}
+	if len(fake.requests()) != 1 || len(other.requests()) != 1 {
+		t.Errorf("expected delivery through the new notifier, got %d and %d", len(fake.requests()), len(other.requests()))
+	}
+	if s.currentRetry().attempts != 1 {
+		t.Errorf("expected the new retry policy, got %+v", s.currentRetry())
+	}
+	if s.Addr().String() != addr {
+		t.Errorf("expected the TCP listener to be kept, got %s", s.Addr())
+	}
+}
+
+func TestReconfigureRebindsListeners(t *testing.T) {
+	dir := t.TempDir()
+	oldSocket := filepath.Join(dir, "old.sock")
+	newSocket := filepath.Join(dir, "new.sock")
+	s := NewServer("127.0.0.1", 0, false, WithNotifier(&fakeNotifier{}), WithUnixSocket(oldSocket, defaultSocketMode, ""))
+	runServer(t, s)
+	oldAddr := s.Addr().String()
+
+	// Held open across the reload, once the server has accepted it
+	conn, err := net.Dial("unix", oldSocket)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	reader := bufio.NewReader(conn)
+	send := func() (string, error) {
+		if err := conn.SetDeadline(time.Now().Add(5 * time.Second)); err != nil {
+			return "", err
+		}
+		if _, err := conn.Write([]byte(`{"title":"T","message":"M"}` + "\n")); err != nil {
+			return "", err
+		}
+		return reader.ReadString('\n')
+	}
+	if resp, err := send(); err != nil || resp != "OK\n" {
+		t.Fatalf("expected OK, got %q (%v)", resp, err)
+	}
+
+	err = s.Reconfigure("localhost", 0, WithUnixSocket(newSocket, 0o660, ""), WithMetricsAddr("127.0.0.1:0"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if s.Addr().String() == oldAddr {
+		t.Error("expected the TCP listener to be re-bound for the new host")
+	}
+	if _, err := net.Dial("tcp", oldAddr); err == nil {
+		t.Error("expected the old TCP listener to be closed")
+	}
+	if _, err := os.Stat(oldSocket); !os.IsNotExist(err) {
+		t.Errorf("expected the old socket to be removed, got %v", err)
+	}
+	if info, err := os.Stat(newSocket); err != nil || info.Mode().Perm() != 0o660 {
+		t.Errorf("expected the new socket with mode 0660, got %v (%v)", info, err)
+	}
+
+	for _, c := range []*clientOptions{
+		{addr: s.Addr().String(), timeout: 5 * time.Second},
+		{socket: newSocket, timeout: 5 * time.Second},
+	} {
+		if resp, err := c.send(NotificationRequest{Title: "T", Message: "M"}); err != nil || resp != "OK" {
+			t.Errorf("expected OK from the new listener, got %q (%v)", resp, err)
+		}
+	}
+	if resp, err := send(); err != nil || resp != "OK\n" {
+		t.Errorf("expected the existing connection to be served, got %q (%v)", resp, err)
+	}
+
+	s.mu.Lock()
+	started := s.metricsServer != nil
+	s.mu.Unlock()
+	if !started {
+		t.Error("expected the metrics server to be started")
+	}
+}
+
+func TestReconfigureRejectsUnusableListener(t *testing.T) {
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { _ = busy.Close() })
+
+	tokens, err := buildTokenSet([]string{"ci:one"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	s := NewServer("127.0.0.1", 0, false, WithNotifier(&fakeNotifier{}), WithTokens(tokens))
+	runServer(t, s)
+
+	err = s.Reconfigure("127.0.0.1", 0, WithMetricsAddr(busy.Addr().String()))
+	if err == nil || !strings.Contains(err.Error(), "failed to listen") {
+		t.Fatalf("expected listen error, got %v", err)
+	}
+	if s.currentTokens().len() != 1 || s.metricsAddr != "" {
+		t.Error("expected the configuration to be left unchanged")
+	}
+}
+
+func TestReloadSettings(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.toml")
+	tokenFile := filepath.Join(dir, "tokens")
+	write := func(path, contents string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(tokenFile, "ops:alpha\n")
+	config := "host = \"127.0.0.1\"\nport = 0\ntoken-file = " + strconv.Quote(tokenFile) + "\n"
+	write(path, config+"token = [\"ci:one\", \"old:gone\"]\n")
+
+	args := []string{"--config", path}
+	getenv := func(string) string { return "" }
+	st := &settings{}
+	if err := st.load(st.flagSet("test", flag.ContinueOnError), args, getenv); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	opts, err := st.options()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	logs, logger := captureLog()
+	s := NewServer(st.host, st.port, false, append(opts, WithNotifier(&fakeNotifier{}), WithLogger(logger))...)
+	runServer(t, s)
+	addr := s.Addr().String()
+
+	write(path, config+"token = [\"ci:two\", \"vm:three\"]\nworkers = 8\n")
+	st = reloadSettings(s, st, args, getenv)
+	if s.currentTokens().len() != 3 {
+		t.Errorf("expected the new tokens, got %d", s.currentTokens().len())
+	}
+	if s.Addr().String() != addr {
+		t.Errorf("expected the TCP listener to be kept, got %s", s.Addr())
+	}
+	for _, want := range []string{
+		`msg="Configuration reloaded" changed=token tokens=3 tokens_added=vm tokens_removed=old tokens_rotated=ci`,
+		`msg="Some changed settings take effect only after a restart" settings=workers`,
+	} {
+		if !strings.Contains(logs.String(), want) {
+			t.Errorf("expected log to contain %q, got:\n%s", want, logs.String())
+		}
+	}
+	if strings.Contains(logs.String(), "two") {
+		t.Errorf("expected token secrets to stay out of the log, got:\n%s", logs.String())
+	}
+
+	// Editing the token file changes no setting but still rotates its tokens
+	write(tokenFile, "ops:beta\n")
+	st = reloadSettings(s, st, args, getenv)
+	if _, ok := s.currentTokens().match("beta"); !ok {
+		t.Error("expected the rotated secret to be accepted")
+	}
+	if want := `msg="Configuration reloaded" changed="" tokens=3 tokens_rotated=ops`; !strings.Contains(logs.String(), want) {
+		t.Errorf("expected log to contain %q, got:\n%s", want, logs.String())
+	}
+
+	write(path, "token = [\"ci:four\"]\nrate-limit = \"fast\"\n")
+	if got := reloadSettings(s, st, args, getenv); got != st {
+		t.Error("expected the current settings to be kept")
+	}
+	if !strings.Contains(logs.String(), `msg="Configuration reload rejected, keeping the current configuration"`) {
+		t.Errorf("expected the rejected reload to be logged, got:\n%s", logs.String())
+	}
+	if s.currentTokens().len() != 3 {
+		t.Errorf("expected the tokens to be kept, got %d", s.currentTokens().len())
+	}
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Request actions. An empty action is treated as actionSend.
+const (
+	actionSend    = "send"
+	actionRemove  = "remove"
+	actionList    = "list"
+	actionCancel  = "cancel"
+	actionHistory = "history"
+	actionPing    = "ping"
+)
+
+// NotificationRequest represents a notification request from a client.
+type NotificationRequest struct {
+	Version      int    `json:"v,omitempty"`
+	Action       string `json:"action,omitempty"`
+	Title        string `json:"title"`
+	Message      string `json:"message"`
+	Subtitle     string `json:"subtitle,omitempty"`
+	Sound        string `json:"sound,omitempty"`
+	Group        string `json:"group,omitempty"`
+	Open         string `json:"open,omitempty"`
+	Activate     string `json:"activate,omitempty"`
+	AppIcon      string `json:"app_icon,omitempty"`
+	ContentImage string `json:"content_image,omitempty"`
+	IgnoreDnD    bool   `json:"ignore_dnd,omitempty"`
+	DedupKey     string `json:"dedup_key,omitempty"`
+	Urgent       bool   `json:"urgent,omitempty"`
+	DeliverAt    string `json:"deliver_at,omitempty"`
+	Delay        string `json:"delay,omitempty"`
+	TTL          string `json:"ttl,omitempty"`
+	ExpiresAt    string `json:"expires_at,omitempty"`
+	ID           string `json:"id,omitempty"`
+	Since        string `json:"since,omitempty"`
+	Until        string `json:"until,omitempty"`
+	Sender       string `json:"sender,omitempty"`
+	Status       string `json:"status,omitempty"`
+	Limit        int    `json:"limit,omitempty"`
+	Token        string `json:"token,omitempty"`
+}
+
+// errMissingFields is returned for requests without a title or message.
+var errMissingFields = errors.New("missing title or message")
+
+// errMissingGroup is returned for group actions without a group.
+var errMissingGroup = errors.New("missing group")
+
+// errMissingID is returned for cancel requests without an ID.
+var errMissingID = errors.New("missing id")
+
+// fieldError reports an invalid value in a single request field.
+type fieldError struct {
+	field  string
+	reason string
+}
+
+func (e fieldError) Error() string {
+	return fmt.Sprintf("invalid %s: %s", e.field, e.reason)
+}
+
+// fieldErrors collects every invalid field in a request.
+type fieldErrors []fieldError
+
+func (e fieldErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, fe := range e {
+		msgs[i] = fe.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// isValidationError reports whether err was caused by a bad request rather
+// than a delivery failure.
+func isValidationError(err error) bool {
+	var fe fieldErrors
+	return errors.Is(err, errMissingFields) || errors.Is(err, errMissingGroup) || errors.Is(err, errMissingID) || errors.As(err, &fe)
+}
+
+// allowedURLSchemes lists the schemes accepted for the open field.
+var allowedURLSchemes = map[string]bool{
+	"http":  true,
+	"https": true,
+}
+
+// bundleIDPattern matches reverse-DNS application bundle identifiers such as
+// com.apple.Terminal.
+var bundleIDPattern = regexp.MustCompile(`^[A-Za-z0-9-]+(\.[A-Za-z0-9-]+)+$`)
+
+// validate checks that the request carries everything needed for its action
+// and that optional fields hold values terminal-notifier will accept.
+func (r NotificationRequest) validate() error {
+	if r.Version != 0 && r.Version != protocolV1 && r.Version != protocolV2 {
+		return fieldErrors{{"v", fmt.Sprintf("unsupported protocol version %d (use 1 or 2)", r.Version)}}
+	}
+
+	switch r.Action {
+	case "", actionSend:
+	case actionRemove, actionList:
+		if r.Group == "" {
+			return errMissingGroup
+		}
+		return nil
+	case actionCancel:
+		if r.ID == "" {
+			return errMissingID
+		}
+		return nil
+	case actionHistory:
+		return r.validateHistory()
+	case actionPing:
+		return nil
+	default:
+		return fieldErrors{{"action", fmt.Sprintf("unknown action %q", r.Action)}}
+	}
+
+	if r.Title == "" || r.Message == "" {
+		return errMissingFields
+	}
+
+	var errs fieldErrors
+	if r.Open != "" {
+		if reason := checkURL(r.Open); reason != "" {
+			errs = append(errs, fieldError{"open", reason})
+		}
+	}
+	if r.Activate != "" && !bundleIDPattern.MatchString(r.Activate) {
+		errs = append(errs, fieldError{"activate", "must be a bundle ID such as com.apple.Terminal"})
+	}
+	if r.AppIcon != "" {
+		if reason := checkImage(r.AppIcon); reason != "" {
+			errs = append(errs, fieldError{"app_icon", reason})
+		}
+	}
+	if r.ContentImage != "" {
+		if reason := checkImage(r.ContentImage); reason != "" {
+			errs = append(errs, fieldError{"content_image", reason})
+		}
+	}
+
+	switch {
+	case r.DeliverAt != "" && r.Delay != "":
+		errs = append(errs, fieldError{"delay", "cannot be combined with deliver_at"})
+	case r.DeliverAt != "":
+		if _, err := time.Parse(time.RFC3339, r.DeliverAt); err != nil {
+			errs = append(errs, fieldError{"deliver_at", "must be an RFC 3339 time such as 2026-10-16T17:00:00+02:00"})
+		}
+	case r.Delay != "":
+		if d, err := time.ParseDuration(r.Delay); err != nil || d < 0 {
+			errs = append(errs, fieldError{"delay", "must be a non-negative duration such as 25m"})
+		}
+	}
+	switch {
+	case r.ExpiresAt != "" && r.TTL != "":
+		errs = append(errs, fieldError{"ttl", "cannot be combined with expires_at"})
+	case r.ExpiresAt != "":
+		if _, err := time.Parse(time.RFC3339, r.ExpiresAt); err != nil {
+			errs = append(errs, fieldError{"expires_at", "must be an RFC 3339 time such as 2026-10-16T17:00:00+02:00"})
+		}
+	case r.TTL != "":
+		if d, err := time.ParseDuration(r.TTL); err != nil || d <= 0 {
+			errs = append(errs, fieldError{"ttl", "must be a positive duration such as 10m"})
+		}
+	}
+	if len(r.DedupKey) > maxDedupKeyLength {
+		errs = append(errs, fieldError{"dedup_key", fmt.Sprintf("must be at most %d bytes", maxDedupKeyLength)})
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// validateHistory checks the filters of a history query.
+func (r NotificationRequest) validateHistory() error {
+	var errs fieldErrors
+	if _, err := parseHistoryTime(r.Since, time.Now()); err != nil {
+		errs = append(errs, fieldError{"since", "must be an RFC 3339 time or a duration such as 1h"})
+	}
+	if _, err := parseHistoryTime(r.Until, time.Now()); err != nil {
+		errs = append(errs, fieldError{"until", "must be an RFC 3339 time or a duration such as 1h"})
+	}
+	if r.Status != "" && !historyStatuses[r.Status] {
+		errs = append(errs, fieldError{"status", fmt.Sprintf("unknown status %q", r.Status)})
+	}
+	if r.Limit < 0 {
+		errs = append(errs, fieldError{"limit", "must not be negative"})
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// resolveSchedule turns a relative delay and ttl into an absolute
+// deliver_at and expires_at, so the request keeps its delivery time and
+// deadline if it is persisted and reloaded. The ttl counts from the
+// delivery time, or from now if the request is not scheduled.
+func (r *NotificationRequest) resolveSchedule(now time.Time) {
+	if d, err := time.ParseDuration(r.Delay); err == nil && r.Delay != "" {
+		r.DeliverAt = now.Add(d).Format(time.RFC3339Nano)
+		r.Delay = ""
+	}
+	if d, err := time.ParseDuration(r.TTL); err == nil && r.TTL != "" {
+		from := now
+		if at := r.scheduledTime(); at.After(now) {
+			from = at
+		}
+		r.ExpiresAt = from.Add(d).Format(time.RFC3339Nano)
+		r.TTL = ""
+	}
+}
+
+// scheduledTime returns when the request should be delivered, or the zero
+// time if it has no deliver_at.
+func (r NotificationRequest) scheduledTime() time.Time {
+	t, err := time.Parse(time.RFC3339, r.DeliverAt)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// expired reports whether the request's expires_at has passed by now.
+func (r NotificationRequest) expired(now time.Time) bool {
+	t, err := time.Parse(time.RFC3339, r.ExpiresAt)
+	return err == nil && !now.Before(t)
+}
+
+// checkURL returns why raw is not an acceptable click-through URL, or an
+// empty string if it is.
+func checkURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme == "" {
+		return "must be an absolute URL"
+	}
+	if !allowedURLSchemes[strings.ToLower(u.Scheme)] {
+		return fmt.Sprintf("URL scheme %q is not allowed (use http or https)", u.Scheme)
+	}
+	if u.Host == "" {
+		return "URL has no host"
+	}
+	return ""
+}
+
+// checkImage returns why ref is not a usable image, or an empty string if it
+// is. Images may be http(s) URLs or absolute paths to existing files.
+func checkImage(ref string) string {
+	if strings.HasPrefix(ref, "http://") || strings.HasPrefix(ref, "https://") {
+		return checkURL(ref)
+	}
+	if !filepath.IsAbs(ref) {
+		return "must be an http(s) URL or an absolute file path"
+	}
+	info, err := os.Stat(ref)
+	if err != nil {
+		return fmt.Sprintf("file %s does not exist", ref)
+	}
+	if info.IsDir() {
+		return fmt.Sprintf("%s is a directory", ref)
+	}
+	return ""
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNotificationRequestValidate(t *testing.T) {
+	icon := filepath.Join(t.TempDir(), "icon.png")
+	if err := os.WriteFile(icon, []byte("png"), 0600); err != nil {
+		t.Fatalf("failed to create icon: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		req     NotificationRequest
+		wantErr string
+	}{
+		{
+			name: "minimal",
+			req:  NotificationRequest{Title: "T", Message: "M"},
+		},
+		{
+			name: "all fields",
+			req: NotificationRequest{
+				Title:        "T",
+				Message:      "M",
+				Subtitle:     "S",
+				Group:        "ci",
+				Open:         "https://ci.example.com/build/1",
+				Activate:     "com.apple.Terminal",
+				AppIcon:      icon,
+				ContentImage: "https://example.com/image.png",
+				IgnoreDnD:    true,
+			},
+		},
+		{
+			name:    "missing title",
+			req:     NotificationRequest{Message: "M"},
+			wantErr: "missing title or message",
+		},
+		{
+			name:    "disallowed URL scheme",
+			req:     NotificationRequest{Title: "T", Message: "M", Open: "file:///etc/passwd"},
+			wantErr: `invalid open: URL scheme "file" is not allowed`,
+		},
+		{
+			name:    "relative URL",
+			req:     NotificationRequest{Title: "T", Message: "M", Open: "ci.example.com"},
+			wantErr: "invalid open: must be an absolute URL",
+		},
+		{
+			name:    "bad bundle ID",
+			req:     NotificationRequest{Title: "T", Message: "M", Activate: "Terminal"},
+			wantErr: "invalid activate: must be a bundle ID",
+		},
+		{
+			name:    "missing image file",
+			req:     NotificationRequest{Title: "T", Message: "M", ContentImage: "/no/such/image.png"},
+			wantErr: "invalid content_image: file /no/such/image.png does not exist",
+		},
+		{
+			name:    "relative image path",
+			req:     NotificationRequest{Title: "T", Message: "M", AppIcon: "icon.png"},
+			wantErr: "invalid app_icon: must be an http(s) URL or an absolute file path",
+		},
+		{
+			name: "scheduled",
+			req:  NotificationRequest{Title: "T", Message: "M", DeliverAt: "2026-10-16T17:00:00+02:00"},
+		},
+		{
+			name:    "bad deliver_at",
+			req:     NotificationRequest{Title: "T", Message: "M", DeliverAt: "5pm"},
+			wantErr: "invalid deliver_at: must be an RFC 3339 time",
+		},
+		{
+			name:    "negative delay",
+			req:     NotificationRequest{Title: "T", Message: "M", Delay: "-5m"},
+			wantErr: "invalid delay: must be a non-negative duration",
+		},
+		{
+			name:    "delay and deliver_at",
+			req:     NotificationRequest{Title: "T", Message: "M", Delay: "5m", DeliverAt: "2026-10-16T17:00:00Z"},
+			wantErr: "invalid delay: cannot be combined with deliver_at",
+		},
+		{
+			name: "ttl",
+			req:  NotificationRequest{Title: "T", Message: "M", TTL: "10m"},
+		},
+		{
+			name:    "zero ttl",
+			req:     NotificationRequest{Title: "T", Message: "M", TTL: "0s"},
+			wantErr: "invalid ttl: must be a positive duration",
+		},
+		{
+			name:    "bad expires_at",
+			req:     NotificationRequest{Title: "T", Message: "M", ExpiresAt: "tomorrow"},
+			wantErr: "invalid expires_at: must be an RFC 3339 time",
+		},
+		{
+			name:    "ttl and expires_at",
+			req:     NotificationRequest{Title: "T", Message: "M", TTL: "5m", ExpiresAt: "2026-10-16T17:00:00Z"},
+			wantErr: "invalid ttl: cannot be combined with expires_at",
+		},
+		{
+			name: "history query",
+			req:  NotificationRequest{Action: "history", Since: "1h", Until: "2026-10-16T17:00:00Z", Status: "failed"},
+		},
+		{
+			name:    "history with bad since",
+			req:     NotificationRequest{Action: "history", Since: "yesterday"},
+			wantErr: "invalid since: must be an RFC 3339 time or a duration",
+		},
+		{
+			name: "ping",
+			req:  NotificationRequest{Action: "ping"},
+		},
+		{
+			name: "protocol version 2",
+			req:  NotificationRequest{Title: "T", Message: "M", Version: 2},
+		},
+		{
+			name:    "unsupported protocol version",
+			req:     NotificationRequest{Title: "T", Message: "M", Version: 3},
+			wantErr: "invalid v: unsupported protocol version 3",
+		},
+		{
+			name:    "cancel without id",
+			req:     NotificationRequest{Action: "cancel"},
+			wantErr: "missing id",
+		},
+		{
+			name:    "several invalid fields",
+			req:     NotificationRequest{Title: "T", Message: "M", Open: "ftp://x", Activate: "x"},
+			wantErr: "invalid open: URL scheme \"ftp\" is not allowed (use http or https); invalid activate",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.req.validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("expected error containing %q", tt.wantErr)
+			}
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expected error containing %q, got %q", tt.wantErr, err.Error())
+			}
+			if !isValidationError(err) {
+				t.Errorf("expected %v to be a validation error", err)
+			}
+		})
+	}
+}
+
+func TestInvalidFieldOverTCP(t *testing.T) {
+	fake := &fakeNotifier{}
+	s := NewServer("localhost", 0, false, WithNotifier(fake))
+
+	got := roundTrip(t, s, `{"title":"T","message":"M","open":"javascript:alert(1)"}`)
+	if !strings.HasPrefix(got, "ERROR: invalid open:") {
+		t.Errorf("expected invalid open error, got %q", got)
+	}
+	if len(fake.requests()) != 0 {
+		t.Error("expected invalid request not to be delivered")
+	}
+}
+
+func TestResolveSchedule(t *testing.T) {
+	now := time.Date(2026, 10, 16, 9, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name          string
+		req           NotificationRequest
+		wantDeliverAt string
+		wantExpiresAt string
+	}{
+		{
+			name: "nothing to resolve",
+			req:  NotificationRequest{Title: "T", Message: "M"},
+		},
+		{
+			name:          "ttl counts from now",
+			req:           NotificationRequest{TTL: "10m"},
+			wantExpiresAt: "2026-10-16T09:10:00Z",
+		},
+		{
+			name:          "ttl counts from the delivery time",
+			req:           NotificationRequest{Delay: "1h", TTL: "10m"},
+			wantDeliverAt: "2026-10-16T10:00:00Z",
+			wantExpiresAt: "2026-10-16T10:10:00Z",
+		},
+		{
+			name:          "expires_at is kept",
+			req:           NotificationRequest{ExpiresAt: "2026-10-16T09:05:00Z"},
+			wantExpiresAt: "2026-10-16T09:05:00Z",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := tt.req
+			req.resolveSchedule(now)
+			if req.DeliverAt != tt.wantDeliverAt || req.ExpiresAt != tt.wantExpiresAt {
+				t.Errorf("expected deliver_at %q and expires_at %q, got %q and %q", tt.wantDeliverAt, tt.wantExpiresAt, req.DeliverAt, req.ExpiresAt)
+			}
+			if req.Delay != "" || req.TTL != "" {
+				t.Errorf("expected delay and ttl to be resolved, got %+v", req)
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"math/rand/v2"
+	"time"
+)
+
+const (
+	// defaultRetryAttempts is how many times delivery of a notification is
+	// attempted before it is given up on.
+	defaultRetryAttempts = 3
+	// defaultRetryDelay is the wait before the first retry; it doubles with
+	// each further attempt.
+	defaultRetryDelay = time.Second
+	// defaultRetryJitter randomizes each delay by up to this fraction so
+	// retries from a burst of failures do not line up.
+	defaultRetryJitter = 0.2
+
+	// maxRetryDelay caps the wait between two attempts.
+	maxRetryDelay = time.Minute
+)
+
+// retryPolicy decides how often and how long apart failed deliveries are
+// retried.
+type retryPolicy struct {
+	attempts  int
+	baseDelay time.Duration
+	jitter    float64
+}
+
+// WithRetry attempts delivery up to attempts times, waiting baseDelay before
+// the first retry and doubling the wait after each further failure. Each
+// wait is randomized by up to jitter (a fraction between 0 and 1).
+func WithRetry(attempts int, baseDelay time.Duration, jitter float64) Option {
+	return func(s *Server) {
+		s.retry = retryPolicy{attempts: attempts, baseDelay: baseDelay, jitter: jitter}
+	}
+}
+
+// backoff returns how long to wait after the given failed attempt,
+// counting from 1.
+func (p retryPolicy) backoff(attempt int) time.Duration {
+	delay := p.baseDelay
+	for i := 1; i < attempt && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	delay = min(delay, maxRetryDelay)
+
+	if p.jitter > 0 && delay > 0 {
+		spread := float64(delay) * min(p.jitter, 1)
+		delay += time.Duration(spread * (2*rand.Float64() - 1))
+	}
+	return delay
+}
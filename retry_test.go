@@ -0,0 +1,108 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ahacop/macos-notify-bridge/internal/testutil"
+)
+
+func TestRetryBackoff(t *testing.T) {
+	p := retryPolicy{attempts: 10, baseDelay: time.Second}
+
+	tests := []struct {
+		attempt int
+		want    time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{20, maxRetryDelay},
+	}
+	for _, tt := range tests {
+		if got := p.backoff(tt.attempt); got != tt.want {
+			t.Errorf("attempt %d: expected %v, got %v", tt.attempt, tt.want, got)
+		}
+	}
+
+	p.jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if got := p.backoff(2); got < time.Second || got > 3*time.Second {
+			t.Fatalf("expected jittered delay within 50%% of 2s, got %v", got)
+		}
+	}
+}
+
+// useFlakyBackend installs a terminal-notifier mock on PATH that fails the
+// first failures runs, and returns its directory.
+func useFlakyBackend(t *testing.T, failures int) string {
+	t.Helper()
+	dir := t.TempDir()
+	if _, err := testutil.CreateFlakyMockTerminalNotifier(dir, failures); err != nil {
+		t.Fatalf("failed to create mock terminal-notifier: %v", err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return dir
+}
+
+func TestRetryRecoversFromTransientFailures(t *testing.T) {
+	mockDir := useFlakyBackend(t, 2)
+	s := NewServer("localhost", 0, false, WithRetry(3, time.Millisecond, 0))
+	t.Cleanup(s.Stop)
+
+	if got := roundTrip(t, s, `{"title":"Flaky","message":"Eventually"}`); got != "OK" {
+		t.Fatalf("expected OK after retries, got %q", got)
+	}
+
+	attempts, err := testutil.ReadAttemptCount(mockDir)
+	if err != nil {
+		t.Fatalf("failed to read attempt count: %v", err)
+	}
+	if attempts != 3 {
+		t.Errorf("expected 3 attempts, got %d", attempts)
+	}
+	logData, err := testutil.ReadNotificationLog(mockDir)
+	if err != nil {
+		t.Fatalf("failed to read notification log: %v", err)
+	}
+	if !strings.Contains(logData, "Title: Flaky") {
+		t.Errorf("expected notification to be delivered, got log %q", logData)
+	}
+}
+
+func TestRetryExhaustedMovesToDeadLetters(t *testing.T) {
+	mockDir := useFlakyBackend(t, 5)
+
+	dir := t.TempDir()
+	sp, err := openSpool(filepath.Join(dir, "spool"), 0, 0)
+	if err != nil {
+		t.Fatalf("failed to open spool: %v", err)
+	}
+	dl, err := openDeadLetterFile(filepath.Join(dir, "dead-letter.jsonl"))
+	if err != nil {
+		t.Fatalf("failed to open dead-letter file: %v", err)
+	}
+	s := NewServer("localhost", 0, false, WithRetry(2, time.Millisecond, 0), WithSpool(sp), WithDeadLetters(dl))
+	t.Cleanup(s.Stop)
+
+	if got := roundTrip(t, s, `{"title":"Doomed","message":"Never"}`); !strings.HasPrefix(got, "ERROR: ") {
+		t.Fatalf("expected error after retries, got %q", got)
+	}
+
+	if attempts, _ := testutil.ReadAttemptCount(mockDir); attempts != 2 {
+		t.Errorf("expected 2 attempts, got %d", attempts)
+	}
+	entries, err := dl.list()
+	if err != nil {
+		t.Fatalf("failed to list dead letters: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Request.Title != "Doomed" || entries[0].Attempts != 2 || entries[0].Error == "" {
+		t.Errorf("expected one dead letter after 2 attempts, got %+v", entries)
+	}
+	if len(sp.files) != 0 {
+		t.Errorf("expected dead letter to be removed from the spool, got %d entries", len(sp.files))
+	}
+}
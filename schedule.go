@@ -0,0 +1,181 @@
+package main
+
+import (
+	"container/heap"
+	"errors"
+	"sync"
+	"time"
+)
+
+// errNotFound is returned when cancelling a notification that is not
+// scheduled.
+var errNotFound = errors.New("notification not found")
+
+// errScheduleNoSpool is returned for notifications scheduled for later when
+// there is no spool to keep them across restarts.
+var errScheduleNoSpool = errors.New("scheduled delivery requires --spool-dir")
+
+// scheduledNotification is a notification waiting for its delivery time.
+type scheduledNotification struct {
+	id    string
+	req   NotificationRequest
+	at    time.Time
+	index int
+}
+
+// scheduleHeap orders scheduled notifications by delivery time.
+type scheduleHeap []*scheduledNotification
+
+func (h scheduleHeap) Len() int           { return len(h) }
+func (h scheduleHeap) Less(i, j int) bool { return h[i].at.Before(h[j].at) }
+func (h scheduleHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *scheduleHeap) Push(x any) {
+	n := x.(*scheduledNotification)
+	n.index = len(*h)
+	*h = append(*h, n)
+}
+
+func (h *scheduleHeap) Pop() any {
+	old := *h
+	n := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	n.index = -1
+	return n
+}
+
+// scheduler holds notifications until their delivery time, using a single
+// timer for the earliest one.
+type scheduler struct {
+	fire func(id string, req NotificationRequest)
+
+	mu     sync.Mutex
+	queue  scheduleHeap
+	byID   map[string]*scheduledNotification
+	timer  *time.Timer
+	closed bool
+}
+
+func newScheduler() *scheduler {
+	return &scheduler{byID: make(map[string]*scheduledNotification)}
+}
+
+// add schedules req to be handed to fire at the given time.
+func (sc *scheduler) add(id string, req NotificationRequest, at time.Time) error {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if sc.closed {
+		return errQueueClosed
+	}
+	n := &scheduledNotification{id: id, req: req, at: at}
+	heap.Push(&sc.queue, n)
+	sc.byID[id] = n
+	sc.resetLocked()
+	return nil
+}
+
+// cancel unschedules the notification with the given ID, returning it.
+func (sc *scheduler) cancel(id string) (NotificationRequest, bool) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	n, ok := sc.byID[id]
+	if !ok {
+		return NotificationRequest{}, false
+	}
+	heap.Remove(&sc.queue, n.index)
+	delete(sc.byID, id)
+	sc.resetLocked()
+	return n.req, true
+}
+
+// len returns the number of scheduled notifications.
+func (sc *scheduler) len() int {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return len(sc.queue)
+}
+
+// resetLocked arms the timer for the earliest scheduled notification.
+func (sc *scheduler) resetLocked() {
+	if sc.timer != nil {
+		sc.timer.Stop()
+		sc.timer = nil
+	}
+	if len(sc.queue) == 0 || sc.closed {
+		return
+	}
+	sc.timer = time.AfterFunc(time.Until(sc.queue[0].at), sc.run)
+}
+
+// run hands every notification that is due to fire.
+func (sc *scheduler) run() {
+	sc.mu.Lock()
+	var due []*scheduledNotification
+	now := time.Now()
+	for len(sc.queue) > 0 && !sc.queue[0].at.After(now) {
+		n := heap.Pop(&sc.queue).(*scheduledNotification)
+		delete(sc.byID, n.id)
+		due = append(due, n)
+	}
+	sc.resetLocked()
+	sc.mu.Unlock()
+
+	for _, n := range due {
+		sc.fire(n.id, n.req)
+	}
+}
+
+// close stops the timer and returns how many notifications were still
+// scheduled.
+func (sc *scheduler) close() int {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	sc.closed = true
+	sc.resetLocked()
+	return len(sc.queue)
+}
+
+// schedule holds req until at, persisting it in the spool so that it
+// survives a restart. Without a spool it is refused.
+func (s *Server) schedule(id string, req NotificationRequest, at time.Time) (result, error) {
+	if s.spool == nil {
+		return result{}, errScheduleNoSpool
+	}
+	if err := s.spool.add(id, req); err != nil {
+		return result{}, err
+	}
+	if err := s.scheduler.add(id, req, at); err != nil {
+		s.spool.remove(id)
+		return result{}, err
+	}
+	s.log.Debug("Scheduled notification", "request_id", id, "deliver_at", at)
+	return result{status: statusScheduled, id: id}, nil
+}
+
+// fireScheduled delivers a scheduled notification once it is due.
+func (s *Server) fireScheduled(id string, req NotificationRequest) {
+	s.log.Debug("Scheduled notification is due", "request_id", id)
+	if _, err := s.dispatch(id, req, false, true); err != nil {
+		s.log.Error("Error queueing scheduled notification", "request_id", id, "error", err)
+	}
+}
+
+// cancel unschedules the notification with the given ID.
+func (s *Server) cancel(id string) error {
+	req, ok := s.scheduler.cancel(id)
+	if !ok {
+		return errNotFound
+	}
+	if s.spool != nil {
+		s.spool.remove(id)
+	}
+	s.forgetDuplicate(req, id)
+	s.updateHistory(id, historyCancelled, nil)
+	s.log.Info("Cancelled scheduled notification", "request_id", id)
+	return nil
+}
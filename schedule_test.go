@@ -0,0 +1,226 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSchedulerOrder(t *testing.T) {
+	sc := newScheduler()
+	var mu sync.Mutex
+	var fired []string
+	done := make(chan struct{})
+	sc.fire = func(id string, req NotificationRequest) {
+		mu.Lock()
+		defer mu.Unlock()
+		fired = append(fired, id)
+		if len(fired) == 3 {
+			close(done)
+		}
+	}
+	t.Cleanup(func() { sc.close() })
+
+	now := time.Now()
+	for _, n := range []struct {
+		id    string
+		delay time.Duration
+	}{{"late", 60 * time.Millisecond}, {"cancelled", 10 * time.Millisecond}, {"early", 20 * time.Millisecond}, {"middle", 40 * time.Millisecond}} {
+		if err := sc.add(n.id, NotificationRequest{}, now.Add(n.delay)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if _, ok := sc.cancel("cancelled"); !ok {
+		t.Fatal("expected scheduled notification to be cancelled")
+	}
+	if _, ok := sc.cancel("cancelled"); ok {
+		t.Error("expected second cancel to find nothing")
+	}
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("scheduled notifications did not fire")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if got := strings.Join(fired, ","); got != "early,middle,late" {
+		t.Errorf("expected early,middle,late, got %s", got)
+	}
+}
+
+func TestScheduledDelivery(t *testing.T) {
+	fake := &fakeNotifier{}
+	s := NewServer("localhost", 0, false, WithNotifier(fake), WithSpool(testSpool(t)))
+	t.Cleanup(s.Stop)
+
+	got := roundTrip(t, s, `{"title":"Reminder","message":"Stand up","delay":"50ms"}`)
+	if id, ok := strings.CutPrefix(got, "SCHEDULED "); !ok || len(id) != 16 {
+		t.Fatalf("expected SCHEDULED <id>, got %q", got)
+	}
+	if n := len(fake.requests()); n != 0 {
+		t.Fatalf("expected nothing delivered before the delay, got %d", n)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for len(fake.requests()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if reqs := fake.requests(); len(reqs) != 1 || reqs[0].Title != "Reminder" {
+		t.Errorf("expected reminder to be delivered, got %+v", reqs)
+	}
+}
+
+func TestCancelScheduled(t *testing.T) {
+	fake := &fakeNotifier{}
+	s := NewServer("localhost", 0, false, WithNotifier(fake), WithSpool(testSpool(t)))
+	t.Cleanup(s.Stop)
+
+	at := time.Now().Add(time.Hour).Format(time.RFC3339)
+	got := roundTrip(t, s, fmt.Sprintf(`{"title":"Meeting","message":"Starts now","deliver_at":%q}`, at))
+	id, ok := strings.CutPrefix(got, "SCHEDULED ")
+	if !ok {
+		t.Fatalf("expected SCHEDULED <id>, got %q", got)
+	}
+
+	if got := roundTrip(t, s, fmt.Sprintf(`{"action":"cancel","id":%q}`, id)); got != "OK" {
+		t.Errorf("expected OK for cancel, got %q", got)
+	}
+	if got := roundTrip(t, s, fmt.Sprintf(`{"action":"cancel","id":%q}`, id)); got != "ERROR: notification not found" {
+		t.Errorf("expected not found for second cancel, got %q", got)
+	}
+	if n := s.scheduler.len(); n != 0 {
+		t.Errorf("expected nothing scheduled, got %d", n)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/notify", strings.NewReader(`{"action":"cancel","id":"unknown"}`))
+	rec := httptest.NewRecorder()
+	s.httpHandler().ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("expected status 404, got %d", rec.Code)
+	}
+}
+
+func TestScheduleRequiresSpool(t *testing.T) {
+	fake := &fakeNotifier{}
+	s := NewServer("localhost", 0, false, WithNotifier(fake))
+	t.Cleanup(s.Stop)
+
+	if got := roundTrip(t, s, `{"title":"Reminder","message":"Stand up","delay":"25m"}`); got != "ERROR: scheduled delivery requires --spool-dir" {
+		t.Errorf("expected scheduling to be refused, got %q", got)
+	}
+	req := httptest.NewRequest(http.MethodPost, "/v1/notify", strings.NewReader(`{"title":"Meeting","message":"Starts now","delay":"1h"}`))
+	rec := httptest.NewRecorder()
+	s.httpHandler().ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotImplemented {
+		t.Errorf("expected status 501, got %d", rec.Code)
+	}
+
+	// A delivery time that has passed needs nothing kept
+	at := time.Now().Add(-time.Minute).Format(time.RFC3339)
+	if got := roundTrip(t, s, fmt.Sprintf(`{"title":"Late","message":"Overdue","deliver_at":%q}`, at)); got != "OK" {
+		t.Errorf("expected a past delivery time to be delivered now, got %q", got)
+	}
+	if n := s.scheduler.len(); n != 0 {
+		t.Errorf("expected nothing scheduled, got %d", n)
+	}
+}
+
+func TestScheduledWaitsForFullQueue(t *testing.T) {
+	sp := testSpool(t)
+	backend := newBlockingNotifier()
+	s := NewServer("localhost", 0, false, WithNotifier(backend), WithQueue(1, 1), WithAsync(), WithSpool(sp))
+	released := false
+	t.Cleanup(func() {
+		if !released {
+			close(backend.release)
+		}
+		s.Stop()
+	})
+
+	got := roundTrip(t, s, `{"title":"Reminder","message":"Stand up","delay":"100ms"}`)
+	id, ok := strings.CutPrefix(got, "SCHEDULED ")
+	if !ok {
+		t.Fatalf("expected SCHEDULED <id>, got %q", got)
+	}
+
+	// Occupy the only worker and fill the only queue slot before it is due
+	if _, err := s.perform(NotificationRequest{Title: "Busy", Message: "1"}, origin{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	<-backend.started
+	if _, err := s.perform(NotificationRequest{Title: "Busy", Message: "2"}, origin{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Once due, the reminder waits for the queue and stays in the spool
+	spooled := func() bool {
+		_, err := os.Stat(sp.path(id))
+		return err == nil
+	}
+	time.Sleep(300 * time.Millisecond)
+	if !spooled() {
+		t.Fatal("expected the reminder to stay spooled while the queue is full")
+	}
+
+	close(backend.release)
+	released = true
+	deadline := time.Now().Add(2 * time.Second)
+	for len(backend.requests()) < 3 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	reqs := backend.requests()
+	if len(reqs) != 3 || reqs[2].Title != "Reminder" {
+		t.Fatalf("expected the reminder to be delivered after the queue drained, got %+v", reqs)
+	}
+	deadline = time.Now().Add(2 * time.Second)
+	for spooled() && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if spooled() {
+		t.Error("expected the reminder to leave the spool once delivered")
+	}
+}
+
+func TestScheduledPersistsAcrossRestart(t *testing.T) {
+	dir := t.TempDir()
+	sp, err := openSpool(dir, 0, 0)
+	if err != nil {
+		t.Fatalf("failed to open spool: %v", err)
+	}
+	s := NewServer("localhost", 0, false, WithNotifier(&fakeNotifier{}), WithSpool(sp))
+	got := roundTrip(t, s, `{"title":"Later","message":"Tomorrow","delay":"30h"}`)
+	id, ok := strings.CutPrefix(got, "SCHEDULED ")
+	if !ok {
+		t.Fatalf("expected SCHEDULED <id>, got %q", got)
+	}
+	s.Stop()
+
+	// The schedule survives, even past the spool's maximum age
+	sp, err = openSpool(dir, 0, 24*time.Hour)
+	if err != nil {
+		t.Fatalf("failed to reopen spool: %v", err)
+	}
+	entries := sp.recovered
+	if len(entries) != 1 || entries[0].ID != id || entries[0].Request.Delay != "" {
+		t.Fatalf("expected the scheduled notification with an absolute time, got %+v", entries)
+	}
+	if at := entries[0].Request.scheduledTime(); time.Until(at) < 29*time.Hour {
+		t.Errorf("expected delivery in about 30 hours, got %v", at)
+	}
+
+	s = NewServer("localhost", 0, false, WithNotifier(&fakeNotifier{}), WithSpool(sp))
+	runServer(t, s)
+	deadline := time.Now().Add(2 * time.Second)
+	for s.scheduler.len() == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := roundTrip(t, s, fmt.Sprintf(`{"action":"cancel","id":%q}`, id)); got != "OK" {
+		t.Errorf("expected replayed notification to be cancellable, got %q", got)
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultSocketMode is the file mode applied to the Unix socket, allowing
+// only the owner to connect.
+const defaultSocketMode os.FileMode = 0600
+
+// WithUnixSocket additionally listens on a Unix domain socket at path,
+// applying mode and, if owner is non-empty, "user[:group]" ownership.
+func WithUnixSocket(path string, mode os.FileMode, owner string) Option {
+	return func(s *Server) {
+		s.socketPath = path
+		s.socketMode = mode
+		s.socketOwner = owner
+	}
+}
+
+// WithoutTCP disables the TCP listener, for use with WithUnixSocket.
+func WithoutTCP() Option {
+	return func(s *Server) {
+		s.noTCP = true
+	}
+}
+
+// listenUnix opens the Unix socket listener, replacing a stale socket left
+// behind by a previous run.
+func (s *Server) listenUnix() (net.Listener, error) {
+	if err := removeStaleSocket(s.socketPath); err != nil {
+		return nil, err
+	}
+
+	listener, err := net.Listen("unix", s.socketPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", s.socketPath, err)
+	}
+	// Remove the socket file when the listener is closed by Stop
+	listener.(*net.UnixListener).SetUnlinkOnClose(true)
+
+	if err := setSocketAccess(s.socketPath, s.socketMode, s.socketOwner); err != nil {
+		_ = listener.Close()
+		return nil, err
+	}
+
+	s.log.Info("Server listening", "addr", "unix:"+s.socketPath)
+	return listener, nil
+}
+
+// setSocketAccess applies mode and, if owner is non-empty, "user[:group]"
+// ownership to the socket at path.
+func setSocketAccess(path string, mode os.FileMode, owner string) error {
+	if err := os.Chmod(path, mode); err != nil {
+		return fmt.Errorf("failed to set mode on %s: %w", path, err)
+	}
+	if owner == "" {
+		return nil
+	}
+	uid, gid, err := lookupOwner(owner)
+	if err != nil {
+		return err
+	}
+	if err := os.Chown(path, uid, gid); err != nil {
+		return fmt.Errorf("failed to set owner on %s: %w", path, err)
+	}
+	return nil
+}
+
+// removeStaleSocket deletes a socket file at path that no process is
+// listening on. It refuses to touch anything that is not a socket, or a
+// socket another server is still accepting connections on.
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to stat %s: %w", path, err)
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+
+	conn, err := net.DialTimeout("unix", path, time.Second)
+	if err == nil {
+		_ = conn.Close()
+		return fmt.Errorf("%s is already in use by another process", path)
+	}
+
+	slog.Info("Removing stale socket", "path", path)
+	if err := os.Remove(path); err != nil {
+		return fmt.Errorf("failed to remove stale socket %s: %w", path, err)
+	}
+	return nil
+}
+
+// lookupOwner resolves a "user[:group]" specification, accepting names or
+// numeric IDs. Without a group, the group is left unchanged.
+func lookupOwner(spec string) (int, int, error) {
+	userName, groupName, _ := strings.Cut(spec, ":")
+
+	uid := -1
+	if userName != "" {
+		id, err := strconv.Atoi(userName)
+		if err != nil {
+			u, err := user.Lookup(userName)
+			if err != nil {
+				return 0, 0, fmt.Errorf("unknown socket owner %q: %w", userName, err)
+			}
+			if id, err = strconv.Atoi(u.Uid); err != nil {
+				return 0, 0, fmt.Errorf("invalid uid for %q: %w", userName, err)
+			}
+		}
+		uid = id
+	}
+
+	gid := -1
+	if groupName != "" {
+		id, err := strconv.Atoi(groupName)
+		if err != nil {
+			g, err := user.LookupGroup(groupName)
+			if err != nil {
+				return 0, 0, fmt.Errorf("unknown socket group %q: %w", groupName, err)
+			}
+			if id, err = strconv.Atoi(g.Gid); err != nil {
+				return 0, 0, fmt.Errorf("invalid gid for %q: %w", groupName, err)
+			}
+		}
+		gid = id
+	}
+
+	return uid, gid, nil
+}
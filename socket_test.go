@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// shortTempDir returns a temporary directory with a path short enough for a
+// Unix socket, which t.TempDir does not guarantee on macOS.
+func shortTempDir(t *testing.T) string {
+	t.Helper()
+	dir, err := os.MkdirTemp("", "mnb")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := os.RemoveAll(dir); err != nil {
+			t.Logf("failed to remove temp dir: %v", err)
+		}
+	})
+	return dir
+}
+
+func TestUnixSocketListener(t *testing.T) {
+	path := filepath.Join(shortTempDir(t), "bridge.sock")
+	fake := &fakeNotifier{}
+	s := NewServer("localhost", 0, false, WithNotifier(fake), WithUnixSocket(path, 0660, ""), WithoutTCP())
+
+	runServer(t, s)
+	if s.Addr() != nil {
+		t.Error("expected no TCP listener")
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("socket not created: %v", err)
+	}
+	if info.Mode().Perm() != 0660 {
+		t.Errorf("expected mode 0660, got %o", info.Mode().Perm())
+	}
+
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	if err := conn.SetDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		t.Fatalf("failed to set deadline: %v", err)
+	}
+	if _, err := conn.Write([]byte(`{"title":"Unix","message":"Hello"}` + "\n")); err != nil {
+		t.Fatalf("failed to write request: %v", err)
+	}
+	response, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		t.Fatalf("failed to read response: %v", err)
+	}
+	if strings.TrimSpace(response) != "OK" {
+		t.Errorf("expected OK, got %q", response)
+	}
+	if err := conn.Close(); err != nil {
+		t.Logf("failed to close connection: %v", err)
+	}
+
+	s.Stop()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("expected socket to be removed on stop, got %v", err)
+	}
+}
+
+func TestRemoveStaleSocket(t *testing.T) {
+	dir := shortTempDir(t)
+
+	// A socket nobody is listening on is removed
+	stale := filepath.Join(dir, "stale.sock")
+	listener, err := net.Listen("unix", stale)
+	if err != nil {
+		t.Fatalf("failed to create socket: %v", err)
+	}
+	listener.(*net.UnixListener).SetUnlinkOnClose(false)
+	if err := listener.Close(); err != nil {
+		t.Fatalf("failed to close listener: %v", err)
+	}
+	if err := removeStaleSocket(stale); err != nil {
+		t.Errorf("unexpected error for stale socket: %v", err)
+	}
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Error("expected stale socket to be removed")
+	}
+
+	// A socket in use is left alone
+	live := filepath.Join(dir, "live.sock")
+	listener, err = net.Listen("unix", live)
+	if err != nil {
+		t.Fatalf("failed to create socket: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := listener.Close(); err != nil {
+			t.Logf("failed to close listener: %v", err)
+		}
+	})
+	if err := removeStaleSocket(live); err == nil {
+		t.Error("expected error for socket in use")
+	}
+
+	// Regular files are never removed
+	file := filepath.Join(dir, "file")
+	if err := os.WriteFile(file, nil, 0600); err != nil {
+		t.Fatalf("failed to create file: %v", err)
+	}
+	if err := removeStaleSocket(file); err == nil {
+		t.Error("expected error for regular file")
+	}
+
+	if err := removeStaleSocket(filepath.Join(dir, "missing.sock")); err != nil {
+		t.Errorf("unexpected error for missing path: %v", err)
+	}
+}
+
+func TestLookupOwner(t *testing.T) {
+	uid, gid, err := lookupOwner("501:20")
+	if err != nil || uid != 501 || gid != 20 {
+		t.Errorf("expected (501, 20), got (%d, %d, %v)", uid, gid, err)
+	}
+
+	uid, gid, err = lookupOwner("501")
+	if err != nil || uid != 501 || gid != -1 {
+		t.Errorf("expected (501, -1), got (%d, %d, %v)", uid, gid, err)
+	}
+
+	if _, _, err := lookupOwner("no-such-user-mnb"); err == nil {
+		t.Error("expected error for unknown user")
+	}
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultSpoolMaxBytes caps the total size of spooled notifications.
+	defaultSpoolMaxBytes = 64 << 20
+	// defaultSpoolMaxAge is how long a spooled notification is kept before
+	// it is discarded undelivered.
+	defaultSpoolMaxAge = 24 * time.Hour
+
+	spoolExt = ".json"
+)
+
+// errSpoolFull is returned when accepting a notification would exceed the
+// spool's size limit.
+var errSpoolFull = errors.New("spool full")
+
+// spoolEntry is a notification persisted until it has been delivered.
+type spoolEntry struct {
+	ID         string              `json:"id"`
+	AcceptedAt time.Time           `json:"accepted_at"`
+	Request    NotificationRequest `json:"request"`
+
+	size int64
+}
+
+// since returns when the entry's age starts counting: when it was accepted
+// or, for a scheduled notification, when it is due.
+func (e spoolEntry) since() time.Time {
+	if at := e.Request.scheduledTime(); at.After(e.AcceptedAt) {
+		return at
+	}
+	return e.AcceptedAt
+}
+
+// spoolFile records what the spool knows about an entry on disk. Its age
+// counts from since: when it was accepted or, if later, scheduled for.
+type spoolFile struct {
+	since time.Time
+	size  int64
+}
+
+// spool persists accepted notifications in a directory, one file per
+// notification, so they survive a restart. Files are written once and
+// removed after successful delivery.
+type spool struct {
+	dir      string
+	maxBytes int64
+	maxAge   time.Duration
+
+	mu        sync.Mutex
+	files     map[string]spoolFile
+	size      int64
+	recovered []spoolEntry
+}
+
+// openSpool opens or creates the spool directory and indexes the entries
+// already in it, discarding any older than maxAge.
+func openSpool(dir string, maxBytes int64, maxAge time.Duration) (*spool, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create spool directory: %w", err)
+	}
+
+	sp := &spool{
+		dir:      dir,
+		maxBytes: maxBytes,
+		maxAge:   maxAge,
+		files:    make(map[string]spoolFile),
+	}
+
+	entries, err := sp.load()
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		sp.files[entry.ID] = spoolFile{since: entry.since(), size: entry.size}
+		sp.size += entry.size
+	}
+	sp.recovered = entries
+	return sp, nil
+}
+
+// WithSpool persists accepted notifications to sp until they are delivered,
+// and replays any left over from a previous run when the server starts.
+func WithSpool(sp *spool) Option {
+	return func(s *Server) {
+		s.spool = sp
+	}
+}
+
+func (sp *spool) path(id string) string {
+	return filepath.Join(sp.dir, id+spoolExt)
+}
+
+// add durably writes req to the spool under id.
+func (sp *spool) add(id string, req NotificationRequest) error {
+	// Never persist credentials
+	req.Token = ""
+	entry := spoolEntry{ID: id, AcceptedAt: time.Now(), Request: req}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to encode spool entry: %w", err)
+	}
+
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+
+	sp.pruneLocked(time.Now())
+	if sp.maxBytes > 0 && sp.size+int64(len(data)) > sp.maxBytes {
+		return errSpoolFull
+	}
+
+	if err := writeFileSync(sp.dir, id+spoolExt, data); err != nil {
+		return err
+	}
+	// Rewriting an entry replaces it
+	if f, ok := sp.files[id]; ok {
+		sp.size -= f.size
+	}
+	sp.files[id] = spoolFile{since: entry.since(), size: int64(len(data))}
+	sp.size += int64(len(data))
+	return nil
+}
+
+// remove deletes the entry for id once it no longer needs to be kept.
+func (sp *spool) remove(id string) {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	sp.removeLocked(id)
+}
+
+func (sp *spool) removeLocked(id string) {
+	f, ok := sp.files[id]
+	if !ok {
+		return
+	}
+	if err := os.Remove(sp.path(id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		slog.Error("Error removing spool entry", "request_id", id, "error", err)
+		return
+	}
+	delete(sp.files, id)
+	sp.size -= f.size
+}
+
+// pruneLocked discards entries older than maxAge.
+func (sp *spool) pruneLocked(now time.Time) {
+	if sp.maxAge <= 0 {
+		return
+	}
+	for id, f := range sp.files {
+		if now.Sub(f.since) > sp.maxAge {
+			slog.Warn("Discarding spooled notification past the max age", "request_id", id, "max_age", sp.maxAge)
+			sp.removeLocked(id)
+		}
+	}
+}
+
+// load reads every entry in the spool directory, oldest first. Entries
+// older than maxAge and files that cannot be parsed are removed.
+func (sp *spool) load() ([]spoolEntry, error) {
+	names, err := os.ReadDir(sp.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read spool directory: %w", err)
+	}
+
+	now := time.Now()
+	var entries []spoolEntry
+	for _, name := range names {
+		path := filepath.Join(sp.dir, name.Name())
+		if strings.HasPrefix(name.Name(), ".tmp-") {
+			// Left behind by a write interrupted before its rename
+			_ = os.Remove(path)
+			continue
+		}
+		if name.IsDir() || !strings.HasSuffix(name.Name(), spoolExt) {
+			continue
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			slog.Error("Error reading spool entry", "path", path, "error", err)
+			continue
+		}
+		var entry spoolEntry
+		if err := json.Unmarshal(data, &entry); err != nil || entry.ID+spoolExt != name.Name() {
+			slog.Warn("Removing corrupt spool entry", "path", path)
+			_ = os.Remove(path)
+			continue
+		}
+		if sp.maxAge > 0 && now.Sub(entry.since()) > sp.maxAge {
+			slog.Warn("Discarding spooled notification past the max age", "request_id", entry.ID, "max_age", sp.maxAge)
+			_ = os.Remove(path)
+			continue
+		}
+		entry.size = int64(len(data))
+		entries = append(entries, entry)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].AcceptedAt.Before(entries[j].AcceptedAt)
+	})
+	return entries, nil
+}
+
+// takeRecovered returns the entries found when the spool was opened, oldest
+// first, and forgets them so they are only replayed once.
+func (sp *spool) takeRecovered() []spoolEntry {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	entries := sp.recovered
+	sp.recovered = nil
+	return entries
+}
+
+// replaySpool queues the notifications left in the spool by a previous run.
+func (s *Server) replaySpool(entries []spoolEntry) {
+	if len(entries) == 0 {
+		return
+	}
+	s.log.Info("Replaying spooled notifications", "count", len(entries))
+	for _, entry := range entries {
+		if at := entry.Request.scheduledTime(); at.After(time.Now()) {
+			if err := s.scheduler.add(entry.ID, entry.Request, at); err != nil {
+				s.log.Warn("Stopped replaying spool", "error", err)
+				return
+			}
+			continue
+		}
+		// Notifications held for quiet hours stay held
+		if s.quiet != nil && s.quiet.mode == quietHold && !entry.Request.Urgent {
+			if until, quiet := s.quiet.until(s.quiet.now()); quiet {
+				s.quiet.hold(pendingNotification{id: entry.ID, req: entry.Request}, until)
+				continue
+			}
+		}
+		if err := s.queue.submitWait(deliveryJob{id: entry.ID, req: entry.Request}); err != nil {
+			s.log.Warn("Stopped replaying spool", "error", err)
+			return
+		}
+	}
+}
+
+// writeFileSync atomically writes data to dir/name, flushing it to disk
+// before it becomes visible under its final name.
+func writeFileSync(dir, name string, data []byte) error {
+	tmp, err := os.CreateTemp(dir, ".tmp-"+name+"-*")
+	if err != nil {
+		return fmt.Errorf("failed to create spool file: %w", err)
+	}
+	tmpName := tmp.Name()
+	cleanup := func() {
+		_ = tmp.Close()
+		_ = os.Remove(tmpName)
+	}
+
+	if _, err := tmp.Write(data); err != nil {
+		cleanup()
+		return fmt.Errorf("failed to write spool file: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		cleanup()
+		return fmt.Errorf("failed to sync spool file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmpName)
+		return fmt.Errorf("failed to close spool file: %w", err)
+	}
+	if err := os.Rename(tmpName, filepath.Join(dir, name)); err != nil {
+		_ = os.Remove(tmpName)
+		return fmt.Errorf("failed to commit spool file: %w", err)
+	}
+
+	// Flush the directory entry so the rename survives a crash
+	if d, err := os.Open(dir); err == nil {
+		_ = d.Sync()
+		_ = d.Close()
+	}
+	return nil
+}
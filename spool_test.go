@@ -0,0 +1,157 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testSpool opens a spool in a temporary directory.
+func testSpool(t *testing.T) *spool {
+	t.Helper()
+	sp, err := openSpool(t.TempDir(), 0, 0)
+	if err != nil {
+		t.Fatalf("failed to open spool: %v", err)
+	}
+	return sp
+}
+
+func TestSpoolAddRemove(t *testing.T) {
+	dir := t.TempDir()
+	sp, err := openSpool(dir, 0, 0)
+	if err != nil {
+		t.Fatalf("failed to open spool: %v", err)
+	}
+
+	if err := sp.add("abc", NotificationRequest{Title: "T", Message: "M", Token: "s3cret"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "abc.json"))
+	if err != nil {
+		t.Fatalf("expected spool file: %v", err)
+	}
+	if strings.Contains(string(data), "s3cret") {
+		t.Errorf("expected token not to be persisted, got %s", data)
+	}
+
+	sp.remove("abc")
+	if _, err := os.Stat(filepath.Join(dir, "abc.json")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected spool file to be removed, got %v", err)
+	}
+	if sp.size != 0 {
+		t.Errorf("expected empty spool, got size %d", sp.size)
+	}
+}
+
+func TestSpoolLimits(t *testing.T) {
+	dir := t.TempDir()
+	sp, err := openSpool(dir, 0, 0)
+	if err != nil {
+		t.Fatalf("failed to open spool: %v", err)
+	}
+
+	req := NotificationRequest{Title: "T", Message: "M"}
+	if err := sp.add("one", req); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// Leave room for less than a second entry
+	sp.maxBytes = sp.size + sp.size/2
+	if err := sp.add("two", req); !errors.Is(err, errSpoolFull) {
+		t.Errorf("expected errSpoolFull, got %v", err)
+	}
+
+	// Expired entries are discarded to make room
+	sp.maxAge = time.Millisecond
+	time.Sleep(5 * time.Millisecond)
+	if err := sp.add("three", req); err != nil {
+		t.Errorf("expected expired entry to be pruned, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "one.json")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected expired entry to be removed, got %v", err)
+	}
+}
+
+func TestSpoolLoad(t *testing.T) {
+	dir := t.TempDir()
+	sp, err := openSpool(dir, 0, 0)
+	if err != nil {
+		t.Fatalf("failed to open spool: %v", err)
+	}
+	for _, id := range []string{"first", "second"} {
+		if err := sp.add(id, NotificationRequest{Title: id, Message: "M"}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, "corrupt.json"), []byte("{"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, ".tmp-partial.json-1"), []byte("{"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := openSpool(dir, 0, 0)
+	if err != nil {
+		t.Fatalf("failed to reopen spool: %v", err)
+	}
+	entries := reopened.takeRecovered()
+	if len(entries) != 2 || entries[0].ID != "first" || entries[1].ID != "second" {
+		t.Fatalf("expected both entries oldest first, got %+v", entries)
+	}
+	if reopened.size != sp.size {
+		t.Errorf("expected size %d, got %d", sp.size, reopened.size)
+	}
+	for _, name := range []string{"corrupt.json", ".tmp-partial.json-1"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("expected %s to be removed, got %v", name, err)
+		}
+	}
+	if again := reopened.takeRecovered(); len(again) != 0 {
+		t.Errorf("expected recovered entries to be returned once, got %+v", again)
+	}
+}
+
+func TestSpoolReplayAfterRestart(t *testing.T) {
+	dir := t.TempDir()
+
+	// The first run accepts a notification but the backend fails
+	sp, err := openSpool(dir, 0, 0)
+	if err != nil {
+		t.Fatalf("failed to open spool: %v", err)
+	}
+	failing := &fakeNotifier{err: errors.New("backend down")}
+	s := NewServer("localhost", 0, false, WithNotifier(failing), WithSpool(sp), WithRetry(1, 0, 0))
+	if got := roundTrip(t, s, `{"title":"Done","message":"Job finished"}`); got != "ERROR: backend down" {
+		t.Fatalf("expected backend error, got %q", got)
+	}
+	s.Stop()
+
+	// The next run delivers it and clears the spool
+	sp, err = openSpool(dir, 0, 0)
+	if err != nil {
+		t.Fatalf("failed to reopen spool: %v", err)
+	}
+	fake := &fakeNotifier{}
+	s = NewServer("localhost", 0, false, WithNotifier(fake), WithSpool(sp))
+	runServer(t, s)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for len(fake.requests()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	reqs := fake.requests()
+	if len(reqs) != 1 || reqs[0].Title != "Done" {
+		t.Fatalf("expected spooled notification to be replayed, got %+v", reqs)
+	}
+
+	s.Stop()
+	names, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 0 {
+		t.Errorf("expected empty spool after delivery, got %d files", len(names))
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// certReloader holds the server certificate and optional client CA pool,
+// and can re-read both from disk without restarting the listener.
+type certReloader struct {
+	certFile     string
+	keyFile      string
+	clientCAFile string
+
+	mu        sync.RWMutex
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+}
+
+// newCertReloader loads the certificate, key and optional client CA bundle.
+// When clientCAFile is set, clients must present a certificate signed by it.
+func newCertReloader(certFile, keyFile, clientCAFile string) (*certReloader, error) {
+	if certFile == "" || keyFile == "" {
+		return nil, errors.New("both a TLS certificate and key are required")
+	}
+
+	r := &certReloader{
+		certFile:     certFile,
+		keyFile:      keyFile,
+		clientCAFile: clientCAFile,
+	}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// reload re-reads the certificate files. On error the previously loaded
+// certificates stay in use.
+func (r *certReloader) reload() error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+
+	var clientCAs *x509.CertPool
+	if r.clientCAFile != "" {
+		pem, err := os.ReadFile(r.clientCAFile)
+		if err != nil {
+			return fmt.Errorf("failed to read client CA file: %w", err)
+		}
+		clientCAs = x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates found in client CA file %s", r.clientCAFile)
+		}
+	}
+
+	r.mu.Lock()
+	r.cert = &cert
+	r.clientCAs = clientCAs
+	r.mu.Unlock()
+	return nil
+}
+
+// tlsConfig returns a configuration that picks up reloaded certificates for
+// every new handshake.
+func (r *certReloader) tlsConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			r.mu.RLock()
+			defer r.mu.RUnlock()
+
+			cfg := &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*r.cert},
+			}
+			if r.clientCAs != nil {
+				cfg.ClientCAs = r.clientCAs
+				cfg.ClientAuth = tls.RequireAndVerifyClientCert
+			}
+			return cfg, nil
+		},
+	}
+}
+
+// WithTLS serves the TCP and HTTP listeners over TLS using the given
+// certificates.
+func WithTLS(r *certReloader) Option {
+	return func(s *Server) {
+		s.tls = r
+	}
+}
+
+// wrapTLS returns listener wrapped so that accepted connections use TLS
+// whenever the server is configured for it, with the certificates current
+// at the time.
+func (s *Server) wrapTLS(listener net.Listener) net.Listener {
+	return &tlsListener{Listener: listener, server: s}
+}
+
+// tlsListener is a listener that starts TLS on accepted connections while
+// its server has certificates, so that Reconfigure can turn TLS on or off
+// or change certificates without re-binding.
+type tlsListener struct {
+	net.Listener
+	server *Server
+}
+
+func (l *tlsListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if certs := l.server.currentTLS(); certs != nil {
+		return tls.Server(conn, certs.tlsConfig()), nil
+	}
+	return conn, nil
+}
+
+// peerName describes the remote end of conn for logs: its address, followed
+// by the client certificate identity when mutual TLS is in use. For TLS
+// connections it completes the handshake, returning an error if it fails.
+func (s *Server) peerName(conn net.Conn) (string, error) {
+	remote := conn.RemoteAddr().String()
+	if addr, ok := conn.LocalAddr().(*net.UnixAddr); ok {
+		remote = "unix:" + addr.Name
+	}
+
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return remote, nil
+	}
+
+	if err := tlsConn.SetDeadline(time.Now().Add(s.idleTimeout)); err != nil {
+		s.log.Debug("Error setting handshake deadline", "error", err)
+	}
+	if err := tlsConn.Handshake(); err != nil {
+		return remote, fmt.Errorf("TLS handshake with %s failed: %w", remote, err)
+	}
+	if err := tlsConn.SetDeadline(time.Time{}); err != nil {
+		s.log.Debug("Error clearing handshake deadline", "error", err)
+	}
+
+	if id := certIdentity(tlsConn.ConnectionState().PeerCertificates); id != "" {
+		return fmt.Sprintf("%s [%s]", remote, id), nil
+	}
+	return remote, nil
+}
+
+// certIdentity returns the sender identity from a verified client
+// certificate chain: its common name, or failing that its first SAN.
+func certIdentity(chain []*x509.Certificate) string {
+	if len(chain) == 0 {
+		return ""
+	}
+	cert := chain[0]
+	switch {
+	case cert.Subject.CommonName != "":
+		return cert.Subject.CommonName
+	case len(cert.DNSNames) > 0:
+		return cert.DNSNames[0]
+	case len(cert.EmailAddresses) > 0:
+		return cert.EmailAddresses[0]
+	case len(cert.URIs) > 0:
+		return cert.URIs[0].String()
+	case len(cert.IPAddresses) > 0:
+		return cert.IPAddresses[0].String()
+	}
+	return ""
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ahacop/macos-notify-bridge/internal/testutil"
+)
+
+// syncBuffer is a bytes.Buffer safe for use as a concurrent log output.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// captureLog returns a debug-level logger and the buffer it writes to.
+func captureLog() (*syncBuffer, *slog.Logger) {
+	buf := &syncBuffer{}
+	return buf, slog.New(slog.NewTextHandler(buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+}
+
+func clientTLSConfig(t *testing.T, certs *testutil.TestCerts, withCert bool) *tls.Config {
+	t.Helper()
+
+	caPEM, err := os.ReadFile(certs.CAFile)
+	if err != nil {
+		t.Fatalf("failed to read CA: %v", err)
+	}
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(caPEM)
+
+	cfg := &tls.Config{RootCAs: roots, ServerName: "localhost"}
+	if withCert {
+		cert, err := tls.LoadX509KeyPair(certs.ClientCertFile, certs.ClientKeyFile)
+		if err != nil {
+			t.Fatalf("failed to load client certificate: %v", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg
+}
+
+// sendTLS sends a single request over TLS and returns the trimmed response.
+func sendTLS(addr string, cfg *tls.Config, line string) (string, error) {
+	conn, err := tls.Dial("tcp", addr, cfg)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+
+	if err := conn.SetDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		return "", err
+	}
+	if _, err := conn.Write([]byte(line + "\n")); err != nil {
+		return "", err
+	}
+	response, err := bufio.NewReader(conn).ReadString('\n')
+	return strings.TrimSpace(response), err
+}
+
+func TestTLSListener(t *testing.T) {
+	certs, err := testutil.GenerateTestCerts(t.TempDir(), "test-vm")
+	if err != nil {
+		t.Fatalf("failed to generate certificates: %v", err)
+	}
+	reloader, err := newCertReloader(certs.ServerCertFile, certs.ServerKeyFile, "")
+	if err != nil {
+		t.Fatalf("failed to load certificates: %v", err)
+	}
+
+	fake := &fakeNotifier{}
+	s := NewServer("127.0.0.1", 0, false, WithNotifier(fake), WithTLS(reloader))
+	runServer(t, s)
+
+	got, err := sendTLS(s.Addr().String(), clientTLSConfig(t, certs, false), `{"title":"TLS","message":"Hello"}`)
+	if err != nil {
+		t.Fatalf("TLS request failed: %v", err)
+	}
+	if got != "OK" {
+		t.Errorf("expected OK, got %q", got)
+	}
+	if len(fake.requests()) != 1 {
+		t.Errorf("expected 1 delivered notification, got %d", len(fake.requests()))
+	}
+}
+
+func TestMutualTLSListener(t *testing.T) {
+	logs, logger := captureLog()
+
+	certs, err := testutil.GenerateTestCerts(t.TempDir(), "test-vm")
+	if err != nil {
+		t.Fatalf("failed to generate certificates: %v", err)
+	}
+	reloader, err := newCertReloader(certs.ServerCertFile, certs.ServerKeyFile, certs.CAFile)
+	if err != nil {
+		t.Fatalf("failed to load certificates: %v", err)
+	}
+
+	s := NewServer("127.0.0.1", 0, true, WithNotifier(&fakeNotifier{}), WithTLS(reloader), WithLogger(logger))
+	runServer(t, s)
+	addr := s.Addr().String()
+
+	if got, err := sendTLS(addr, clientTLSConfig(t, certs, false), `{"title":"T","message":"M"}`); err == nil && got == "OK" {
+		t.Error("expected request without client certificate to be rejected")
+	}
+
+	got, err := sendTLS(addr, clientTLSConfig(t, certs, true), `{"title":"T","message":"M"}`)
+	if err != nil {
+		t.Fatalf("mutual TLS request failed: %v", err)
+	}
+	if got != "OK" {
+		t.Errorf("expected OK, got %q", got)
+	}
+	if !strings.Contains(logs.String(), "[test-vm]") {
+		t.Errorf("expected client identity in logs, got:\n%s", logs.String())
+	}
+}
+
+func TestCertReloaderReload(t *testing.T) {
+	dir := t.TempDir()
+	certs, err := testutil.GenerateTestCerts(dir, "test-vm")
+	if err != nil {
+		t.Fatalf("failed to generate certificates: %v", err)
+	}
+	reloader, err := newCertReloader(certs.ServerCertFile, certs.ServerKeyFile, "")
+	if err != nil {
+		t.Fatalf("failed to load certificates: %v", err)
+	}
+	original := reloader.cert.Certificate[0]
+
+	// Regenerating in place simulates a certificate rotation
+	if _, err := testutil.GenerateTestCerts(dir, "test-vm"); err != nil {
+		t.Fatalf("failed to regenerate certificates: %v", err)
+	}
+	if err := reloader.reload(); err != nil {
+		t.Fatalf("reload failed: %v", err)
+	}
+	rotated := reloader.cert.Certificate[0]
+	if bytes.Equal(original, rotated) {
+		t.Error("expected certificate to change after reload")
+	}
+
+	// A broken certificate is rejected and the previous one kept
+	if err := os.WriteFile(certs.ServerCertFile, []byte("garbage"), 0600); err != nil {
+		t.Fatalf("failed to corrupt certificate: %v", err)
+	}
+	if err := reloader.reload(); err == nil {
+		t.Error("expected reload of corrupt certificate to fail")
+	}
+	if !bytes.Equal(reloader.cert.Certificate[0], rotated) {
+		t.Error("expected previous certificate to be kept after failed reload")
+	}
+}
+
+func TestNewCertReloaderRequiresKeyPair(t *testing.T) {
+	if _, err := newCertReloader("cert.pem", "", ""); err == nil {
+		t.Error("expected error without a key")
+	}
+}